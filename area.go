@@ -0,0 +1,225 @@
+package carto
+
+import (
+	"fmt"
+	"math"
+)
+
+// Bounds is an area of the world in block coordinates, both corners are included
+type Bounds struct {
+	MinX int `cty:"min_x"`
+	MinZ int `cty:"min_z"`
+	MaxX int `cty:"max_x"`
+	MaxZ int `cty:"max_z"`
+}
+
+// overlaps reports whether the blocks from (minX, minZ) to (maxX, maxZ) are
+// at least partially within the bounds
+func (b Bounds) overlaps(minX, minZ, maxX, maxZ int) bool {
+	return minX <= b.MaxX && maxX >= b.MinX && minZ <= b.MaxZ && maxZ >= b.MinZ
+}
+
+// Polygon is a closed outline of x, z block coordinates
+type Polygon [][2]float64
+
+// RenderArea limits the chunks rendered for a map to bounds and polygons,
+// chunks only partially within the area are rendered whole
+type RenderArea struct {
+	Bounds *Bounds
+
+	// Include limits the area to chunks overlapping any of its polygons,
+	// chunks entirely within one of the Exclude polygons are skipped
+	Include []Polygon
+	Exclude []Polygon
+}
+
+// newPolygon converts the points of a polygon from the config
+func newPolygon(points [][]int) (Polygon, error) {
+	if len(points) < 3 {
+		return nil, fmt.Errorf("polygon needs at least 3 points, got %d", len(points))
+	}
+
+	polygon := make(Polygon, 0, len(points))
+	for _, point := range points {
+		if len(point) != 2 {
+			return nil, fmt.Errorf("polygon point %v must be [x, z]", point)
+		}
+		polygon = append(polygon, [2]float64{float64(point[0]), float64(point[1])})
+	}
+	return polygon, nil
+}
+
+// rect is an area of blocks in continuous coordinates, a block covers one unit
+type rect struct {
+	minX, minZ, maxX, maxZ float64
+}
+
+// rectInset keeps blocks which only share an edge with a polygon outside of it
+const rectInset = 0.001
+
+// blockRect returns the area covered by the blocks from (minX, minZ) to
+// (maxX, maxZ) inclusive
+func blockRect(minX, minZ, maxX, maxZ int) rect {
+	return rect{
+		float64(minX) + rectInset,
+		float64(minZ) + rectInset,
+		float64(maxX+1) - rectInset,
+		float64(maxZ+1) - rectInset,
+	}
+}
+
+func (r rect) corners() [4][2]float64 {
+	return [4][2]float64{{r.minX, r.minZ}, {r.maxX, r.minZ}, {r.maxX, r.maxZ}, {r.minX, r.maxZ}}
+}
+
+func (r rect) contains(p [2]float64) bool {
+	return p[0] >= r.minX && p[0] <= r.maxX && p[1] >= r.minZ && p[1] <= r.maxZ
+}
+
+// edges calls fn with every edge of a closed outline
+func edges(points [][2]float64, fn func(a, b [2]float64) bool) bool {
+	for i := range points {
+		if fn(points[i], points[(i+1)%len(points)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentsCross reports whether two segments intersect, including touching
+func segmentsCross(a, b, c, d [2]float64) bool {
+	orient := func(p, q, r [2]float64) float64 {
+		return (q[0]-p[0])*(r[1]-p[1]) - (q[1]-p[1])*(r[0]-p[0])
+	}
+	within := func(p, q, r [2]float64) bool {
+		return math.Min(p[0], q[0]) <= r[0] && r[0] <= math.Max(p[0], q[0]) && math.Min(p[1], q[1]) <= r[1] && r[1] <= math.Max(p[1], q[1])
+	}
+
+	d1, d2 := orient(c, d, a), orient(c, d, b)
+	d3, d4 := orient(a, b, c), orient(a, b, d)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && within(c, d, a)) || (d2 == 0 && within(c, d, b)) || (d3 == 0 && within(a, b, c)) || (d4 == 0 && within(a, b, d))
+}
+
+// containsPoint tests a point against the polygon with the even-odd rule
+func (p Polygon) containsPoint(point [2]float64) bool {
+	inside := false
+	edges(p, func(a, b [2]float64) bool {
+		if (a[1] > point[1]) != (b[1] > point[1]) && point[0] < (b[0]-a[0])*(point[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+		return false
+	})
+	return inside
+}
+
+// crossesRect reports whether any edge of the polygon touches an edge of r
+func (p Polygon) crossesRect(r rect) bool {
+	corners := r.corners()
+	return edges(p, func(a, b [2]float64) bool {
+		return edges(corners[:], func(c, d [2]float64) bool {
+			return segmentsCross(a, b, c, d)
+		})
+	})
+}
+
+func (p Polygon) overlapsRect(r rect) bool {
+	for _, point := range p {
+		if r.contains(point) {
+			return true
+		}
+	}
+	return p.containsPoint(r.corners()[0]) || p.crossesRect(r)
+}
+
+func (p Polygon) containsRect(r rect) bool {
+	for _, corner := range r.corners() {
+		if !p.containsPoint(corner) {
+			return false
+		}
+	}
+	return !p.crossesRect(r)
+}
+
+// overlaps reports whether any part of the blocks from (minX, minZ) to (maxX,
+// maxZ) is within the area
+func (a *RenderArea) overlaps(minX, minZ, maxX, maxZ int) bool {
+	if a.Bounds != nil && !a.Bounds.overlaps(minX, minZ, maxX, maxZ) {
+		return false
+	}
+
+	r := blockRect(minX, minZ, maxX, maxZ)
+	if len(a.Include) > 0 {
+		included := false
+		for _, polygon := range a.Include {
+			if polygon.overlapsRect(r) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, polygon := range a.Exclude {
+		if polygon.containsRect(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsRegion reports whether any chunk of a region may be within the area
+func (a *RenderArea) containsRegion(x, z int) bool {
+	return a == nil || a.overlaps(x*512, z*512, x*512+511, z*512+511)
+}
+
+// containsChunk reports whether a chunk is at least partially within the area
+func (a *RenderArea) containsChunk(x, z int) bool {
+	return a == nil || a.overlaps(x*16, z*16, x*16+15, z*16+15)
+}
+
+// containsBlock reports whether a block is within the area
+func (a *RenderArea) containsBlock(x, z int) bool {
+	return a == nil || a.overlaps(x, z, x, z)
+}
+
+// Extent returns the bounds enclosing the area, nil if it is unbounded. The
+// minimum is greater than the maximum when the include polygons are outside of
+// the bounds, MapConfigBlock.Area rejects such areas.
+func (a *RenderArea) Extent() *Bounds {
+	if a == nil {
+		return nil
+	}
+
+	var extent *Bounds
+	if len(a.Include) > 0 {
+		extent = &Bounds{MinX: math.MaxInt, MinZ: math.MaxInt, MaxX: math.MinInt, MaxZ: math.MinInt}
+		for _, polygon := range a.Include {
+			for _, point := range polygon {
+				extent.MinX = min(extent.MinX, int(point[0]))
+				extent.MinZ = min(extent.MinZ, int(point[1]))
+				extent.MaxX = max(extent.MaxX, int(point[0]))
+				extent.MaxZ = max(extent.MaxZ, int(point[1]))
+			}
+		}
+	}
+
+	if a.Bounds == nil {
+		return extent
+	}
+	if extent == nil {
+		bounds := *a.Bounds
+		return &bounds
+	}
+
+	return &Bounds{
+		MinX: max(extent.MinX, a.Bounds.MinX),
+		MinZ: max(extent.MinZ, a.Bounds.MinZ),
+		MaxX: min(extent.MaxX, a.Bounds.MaxX),
+		MaxZ: min(extent.MaxZ, a.Bounds.MaxZ),
+	}
+}
@@ -6,42 +6,140 @@ import (
 	"image"
 	"image/png"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
+// AssetFile is a single file within one of the sources of an AssetLoader
+type AssetFile interface {
+	Open() (io.ReadCloser, error)
+}
+
+// AssetLoader resolves assets and data files through a stack of sources, files
+// from sources added later take priority over those added before them
 type AssetLoader struct {
-	Files map[string]*zip.File
+	Files map[string]AssetFile
+
+	// Precomputed and ColorOverrides are used by every Palette created from
+	// this loader before falling back to the assets
+	Precomputed    *PaletteFile
+	ColorOverrides []ColorOverride
+
+	readers []*zip.ReadCloser
+}
+
+type directoryFile struct {
+	path string
+}
 
-	reader *zip.ReadCloser
+func (f directoryFile) Open() (io.ReadCloser, error) {
+	return os.Open(f.path)
+}
+
+// NewAssetLoader creates a loader without any sources, packs can be added with AddPack
+func NewAssetLoader() *AssetLoader {
+	return &AssetLoader{
+		Files: make(map[string]AssetFile),
+	}
 }
 
 func NewAssetLoaderFromClientJAR(path string) (*AssetLoader, error) {
-	r, err := zip.OpenReader(path)
+	a := NewAssetLoader()
+	err := a.addZip(path)
 	if err != nil {
 		return nil, err
 	}
+	return a, nil
+}
+
+func isAssetPath(name string) bool {
+	return strings.HasPrefix(name, "assets/") || strings.HasPrefix(name, "data/")
+}
+
+// AddPack stacks a resource pack or mod on top of the existing sources, path
+// can be a zip, a jar or an unpacked directory
+func (a *AssetLoader) AddPack(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return a.addDirectory(path)
+	}
+	return a.addZip(path)
+}
+
+func (a *AssetLoader) addZip(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
 
-	files := make(map[string]*zip.File)
 	for _, f := range r.File {
-		if !strings.HasPrefix(f.Name, "assets/") && !strings.HasPrefix(f.Name, "data/") {
+		if !isAssetPath(f.Name) || f.FileInfo().IsDir() {
 			continue
 		}
-		files[f.Name] = f
+		a.Files[f.Name] = f
 	}
 
-	return &AssetLoader{
-		Files:  files,
-		reader: r,
-	}, nil
+	a.readers = append(a.readers, r)
+	return nil
 }
 
-func (a *AssetLoader) LoadPNG(name string) (image.Image, error) {
+func (a *AssetLoader) addDirectory(path string) error {
+	return filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(path, filePath)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if isAssetPath(name) {
+			a.Files[name] = directoryFile{path: filePath}
+		}
+		return nil
+	})
+}
+
+// assetPath returns the path of a namespaced resource, names without a
+// namespace are in the minecraft namespace
+func assetPath(root, kind, name, ext string) string {
+	namespace, path, ok := strings.Cut(name, ":")
+	if !ok {
+		namespace, path = "minecraft", name
+	}
+	return fmt.Sprintf("%s/%s/%s/%s.%s", root, namespace, kind, path, ext)
+}
+
+// namespacedName adds the minecraft namespace to a resource name without one
+func namespacedName(name string) string {
+	if strings.Contains(name, ":") {
+		return name
+	}
+	return "minecraft:" + name
+}
+
+func (a *AssetLoader) open(name string) (io.ReadCloser, error) {
 	file, ok := a.Files[name]
 	if !ok {
 		return nil, fmt.Errorf("file %s does not exist", name)
 	}
+	return file.Open()
+}
 
-	fd, err := file.Open()
+func (a *AssetLoader) LoadPNG(name string) (image.Image, error) {
+	fd, err := a.open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -51,12 +149,7 @@ func (a *AssetLoader) LoadPNG(name string) (image.Image, error) {
 }
 
 func (a *AssetLoader) LoadRaw(name string) ([]byte, error) {
-	file, ok := a.Files[name]
-	if !ok {
-		return nil, fmt.Errorf("file %s does not exist", name)
-	}
-
-	fd, err := file.Open()
+	fd, err := a.open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -66,5 +159,7 @@ func (a *AssetLoader) LoadRaw(name string) ([]byte, error) {
 }
 
 func (a *AssetLoader) Close() {
-	a.reader.Close()
+	for _, r := range a.readers {
+		r.Close()
+	}
 }
@@ -1,24 +1,59 @@
 package carto
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"image"
 	"image/color"
-	"log"
 	"math"
-	"math/bits"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
-	"github.com/Tnze/go-mc/level"
 	"github.com/Tnze/go-mc/save"
-	"github.com/muesli/gamut"
+	"github.com/lucasb-eyer/go-colorful"
 )
 
 type Biome struct {
-	Temperature float64 `json:"temperature"`
-	Downfall    float64 `json:"downfall"`
+	Temperature float64      `json:"temperature"`
+	Downfall    float64      `json:"downfall"`
+	Effects     BiomeEffects `json:"effects"`
+}
+
+// BiomeEffects are the colors a biome definition sets, colors which are not
+// set come from the colormaps
+type BiomeEffects struct {
+	GrassColor         *int   `json:"grass_color"`
+	FoliageColor       *int   `json:"foliage_color"`
+	WaterColor         *int   `json:"water_color"`
+	GrassColorModifier string `json:"grass_color_modifier"`
+}
+
+var defaultWaterColor = rgbColor(0x3f76e4)
+
+// swampGrassColor is the more common of the two colors swamps alternate
+// between, the choice depends on noise which is not worth reproducing
+var swampGrassColor = rgbColor(0x6a7039)
+
+// rgbColor converts a color packed into an int by the biome definitions
+func rgbColor(v int) color.RGBA {
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}
+}
+
+// modifyGrassColor applies the grass_color_modifier of a biome
+func (e *BiomeEffects) modifyGrassColor(clr color.Color) color.Color {
+	switch e.GrassColorModifier {
+	case "dark_forest":
+		r, g, b, _ := clr.RGBA()
+		v := int(r>>8)<<16 | int(g>>8)<<8 | int(b>>8)
+		return rgbColor(((v & 0xfefefe) + 0x28340a) >> 1)
+	case "swamp":
+		return swampGrassColor
+	}
+	return clr
 }
 
 func (b *Biome) ColorMapCoords() (int, int) {
@@ -28,72 +63,284 @@ func (b *Biome) ColorMapCoords() (int, int) {
 	return x, y
 }
 
+// biomeColors are the colors used for vanilla biomes, other biomes get a color
+// derived from their name so it does not change between versions
+var biomeColors = map[string]color.RGBA{
+	"minecraft:badlands":                 rgbColor(0xd94515),
+	"minecraft:bamboo_jungle":            rgbColor(0x768e14),
+	"minecraft:basalt_deltas":            rgbColor(0x403636),
+	"minecraft:beach":                    rgbColor(0xfade55),
+	"minecraft:birch_forest":             rgbColor(0x307444),
+	"minecraft:cherry_grove":             rgbColor(0xffa6c8),
+	"minecraft:cold_ocean":               rgbColor(0x202070),
+	"minecraft:crimson_forest":           rgbColor(0xdd0808),
+	"minecraft:dark_forest":              rgbColor(0x40511a),
+	"minecraft:deep_cold_ocean":          rgbColor(0x202038),
+	"minecraft:deep_dark":                rgbColor(0x0a1e1e),
+	"minecraft:deep_frozen_ocean":        rgbColor(0x404090),
+	"minecraft:deep_lukewarm_ocean":      rgbColor(0x000040),
+	"minecraft:deep_ocean":               rgbColor(0x000030),
+	"minecraft:desert":                   rgbColor(0xfa9418),
+	"minecraft:dripstone_caves":          rgbColor(0x86592a),
+	"minecraft:end_barrens":              rgbColor(0x7070cc),
+	"minecraft:end_highlands":            rgbColor(0x9090ff),
+	"minecraft:end_midlands":             rgbColor(0x8080e6),
+	"minecraft:eroded_badlands":          rgbColor(0xff6d3d),
+	"minecraft:flower_forest":            rgbColor(0x2d8e49),
+	"minecraft:forest":                   rgbColor(0x056621),
+	"minecraft:frozen_ocean":             rgbColor(0x7070d6),
+	"minecraft:frozen_peaks":             rgbColor(0xa0a0ff),
+	"minecraft:frozen_river":             rgbColor(0xa0a0ff),
+	"minecraft:grove":                    rgbColor(0x47726c),
+	"minecraft:ice_spikes":               rgbColor(0xb4dcdc),
+	"minecraft:jagged_peaks":             rgbColor(0xdcdcc8),
+	"minecraft:jungle":                   rgbColor(0x537b09),
+	"minecraft:lukewarm_ocean":           rgbColor(0x000090),
+	"minecraft:lush_caves":               rgbColor(0x4a7a1c),
+	"minecraft:mangrove_swamp":           rgbColor(0x2ccc8e),
+	"minecraft:meadow":                   rgbColor(0x60a445),
+	"minecraft:mushroom_fields":          rgbColor(0xff00ff),
+	"minecraft:nether_wastes":            rgbColor(0xbf3b3b),
+	"minecraft:ocean":                    rgbColor(0x000070),
+	"minecraft:old_growth_birch_forest":  rgbColor(0x589c6c),
+	"minecraft:old_growth_pine_taiga":    rgbColor(0x596651),
+	"minecraft:old_growth_spruce_taiga":  rgbColor(0x818e79),
+	"minecraft:pale_garden":              rgbColor(0x9a9a8c),
+	"minecraft:plains":                   rgbColor(0x8db360),
+	"minecraft:river":                    rgbColor(0x0000ff),
+	"minecraft:savanna":                  rgbColor(0xbdb25f),
+	"minecraft:savanna_plateau":          rgbColor(0xa79d64),
+	"minecraft:small_end_islands":        rgbColor(0x8080ff),
+	"minecraft:snowy_beach":              rgbColor(0xfaf0c0),
+	"minecraft:snowy_plains":             rgbColor(0xffffff),
+	"minecraft:snowy_slopes":             rgbColor(0xc4c4c4),
+	"minecraft:snowy_taiga":              rgbColor(0x31554a),
+	"minecraft:soul_sand_valley":         rgbColor(0x5e3830),
+	"minecraft:sparse_jungle":            rgbColor(0x628b17),
+	"minecraft:stony_peaks":              rgbColor(0x7b8f74),
+	"minecraft:stony_shore":              rgbColor(0xa2a284),
+	"minecraft:sunflower_plains":         rgbColor(0xb5db88),
+	"minecraft:swamp":                    rgbColor(0x07f9b2),
+	"minecraft:taiga":                    rgbColor(0x0b6659),
+	"minecraft:the_end":                  rgbColor(0x8080ff),
+	"minecraft:the_void":                 rgbColor(0x000000),
+	"minecraft:warm_ocean":               rgbColor(0x0000ac),
+	"minecraft:warped_forest":            rgbColor(0x49907b),
+	"minecraft:windswept_forest":         rgbColor(0x5b7352),
+	"minecraft:windswept_gravelly_hills": rgbColor(0x888888),
+	"minecraft:windswept_hills":          rgbColor(0x606060),
+	"minecraft:windswept_savanna":        rgbColor(0xe5da87),
+	"minecraft:wooded_badlands":          rgbColor(0xb09765),
+}
+
+// hashedBiomeColor derives a pastel color from a biome name
+func hashedBiomeColor(name string) color.Color {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+
+	hue := float64(sum % 360)
+	saturation := 0.4 + float64((sum>>9)%30)/100
+	lightness := 0.55 + float64((sum>>17)%25)/100
+	r, g, b := colorful.Hsl(hue, saturation, lightness).Clamped().RGB255()
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+// LegendEntry is a single color shown in a layers legend
+type LegendEntry struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
 type BiomeRenderer struct {
-	biomes map[string]color.Color
+	overrides map[string]color.Color
+
+	// known are the biomes defined in the data files
+	known map[string]struct{}
+
+	lock          sync.Mutex
+	colors        map[string]color.Color
+	seen          map[string]struct{}
+	missingBiomes map[string]struct{}
 }
 
-func NewBiomeRenderer(loader *AssetLoader) *BiomeRenderer {
-	biomes := make(map[string]color.Color)
+func init() {
+	RegisterRenderer(RendererInfo{
+		Name:        "biome",
+		Description: "colors each column by the biome at its surface",
+		Options: []RendererOption{
+			{Name: "color.<biome>", Default: "", Description: "color used for a biome, e.g. color.minecraft:plains = #8db360"},
+		},
+		Factory: func(opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error) {
+			renderer, err := NewBiomeRenderer(opts, assetLoader)
+			if err != nil {
+				return nil, err
+			}
+			return renderer, nil
+		},
+	})
+}
 
-	biomeNames := []string{}
+// listBiomes returns the sorted names of every biome in the data files,
+// data/<namespace>/worldgen/biome/<name>.json, mods may add their own namespaces
+func listBiomes(loader *AssetLoader) []string {
+	names := make(map[string]struct{})
 	for path := range loader.Files {
-		if strings.HasPrefix(path, "data/minecraft/worldgen/biome/") {
-			name := strings.TrimSuffix(filepath.Base(path), ".json")
-			biomeNames = append(biomeNames, fmt.Sprintf("minecraft:%s", name))
+		parts := strings.SplitN(path, "/", 5)
+		if len(parts) == 5 && parts[0] == "data" && parts[2] == "worldgen" && parts[3] == "biome" && strings.HasSuffix(parts[4], ".json") {
+			names[fmt.Sprintf("%s:%s", parts[1], strings.TrimSuffix(parts[4], ".json"))] = struct{}{}
 		}
 	}
 
-	colors, err := gamut.Generate(len(biomeNames), gamut.PastelGenerator{})
-	if err != nil {
-		log.Panicf("Failed to generate color palette for biomes: %v", err)
+	// without a client JAR the biomes come from the precomputed palette
+	if loader.Precomputed != nil {
+		for name := range loader.Precomputed.Biomes {
+			names[name] = struct{}{}
+		}
 	}
+	return sortedKeys(names)
+}
 
-	sort.Strings(biomeNames)
-	for idx, biome := range biomeNames {
-		biomes[biome] = colors[idx]
+func NewBiomeRenderer(opts *ChunkRenderOpts, loader *AssetLoader) (*BiomeRenderer, error) {
+	overrides := make(map[string]color.Color)
+	for key, value := range opts.GetPrefixed("color.") {
+		clr, err := parseHexColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("biome %s: %v", key, err)
+		}
+		overrides[namespacedName(key)] = clr
+	}
+
+	known := make(map[string]struct{})
+	for _, name := range listBiomes(loader) {
+		known[name] = struct{}{}
 	}
 
 	return &BiomeRenderer{
-		biomes: biomes,
+		overrides:     overrides,
+		known:         known,
+		colors:        make(map[string]color.Color),
+		seen:          make(map[string]struct{}),
+		missingBiomes: make(map[string]struct{}),
+	}, nil
+}
+
+// biomeColor returns the color of a biome, the caller must hold the lock
+func (c *BiomeRenderer) biomeColor(name string) color.Color {
+	if clr, ok := c.colors[name]; ok {
+		return clr
+	}
+
+	var clr color.Color
+	if override, ok := c.overrides[name]; ok {
+		clr = override
+	} else if builtin, ok := biomeColors[name]; ok {
+		clr = builtin
+	} else {
+		clr = hashedBiomeColor(name)
 	}
+
+	if _, ok := c.known[name]; !ok {
+		c.missingBiomes[name] = struct{}{}
+	}
+
+	c.colors[name] = clr
+	c.seen[name] = struct{}{}
+	return clr
 }
 
-func (c *BiomeRenderer) Finalize(path string) error {
+func (c *BiomeRenderer) GetMissingBlockStates() []string {
 	return nil
 }
 
+func (c *BiomeRenderer) GetMissingBiomes() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return sortedKeys(c.missingBiomes)
+}
+
+func (c *BiomeRenderer) LegendFile() string {
+	return "biomes.json"
+}
+
+// Finalize writes the legend of every biome drawn, biomes from previous builds
+// are kept as their regions may not have been rendered this time. Clean builds
+// remove the previous legend before rendering.
+func (c *BiomeRenderer) Finalize(path string) error {
+	legendPath := filepath.Join(path, c.LegendFile())
+
+	entries := make(map[string]string)
+	if data, err := os.ReadFile(legendPath); err == nil {
+		var previous []LegendEntry
+		if json.Unmarshal(data, &previous) == nil {
+			for _, entry := range previous {
+				entries[entry.Name] = entry.Color
+			}
+		}
+	}
+
+	c.lock.Lock()
+	for name := range c.seen {
+		entries[name] = formatHexColor(c.colors[name])
+	}
+	c.seen = make(map[string]struct{})
+	c.lock.Unlock()
+
+	// colors from previous builds may have since been overridden
+	legend := []LegendEntry{}
+	for name, value := range entries {
+		if override, ok := c.overrides[name]; ok {
+			value = formatHexColor(override)
+		}
+		legend = append(legend, LegendEntry{Name: name, Color: value})
+	}
+	sort.Slice(legend, func(i, j int) bool {
+		return legend[i].Name < legend[j].Name
+	})
+
+	data, err := json.Marshal(legend)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(legendPath, data, 0644)
+}
+
 func (c *BiomeRenderer) ImageSize() (int, int) {
 	return 16, 16
 }
 
 func (c *BiomeRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error) {
 	img := image.NewRGBA64(image.Rect(0, 0, 16, 16))
-	bitsForHeight := bits.Len(uint(len(chunk.Sections))*16 + 1)
-	motionBlocking := level.NewBitStorage(bitsForHeight, 16*16, chunk.Heightmaps["MOTION_BLOCKING"])
+	motionBlocking := chunkHeightmap(chunk, "MOTION_BLOCKING")
+	cache := newSectionCache(nil, chunk)
 
+	// the biomes are collected first so the lock is only taken once per chunk
+	var biomes [256]save.BiomeState
 	for x := 0; x < 16; x++ {
 		for z := 0; z < 16; z++ {
 			heightmapIndex := ((z) * 16) + x
-			yStart := motionBlocking.Get(heightmapIndex)
-			sectionY := yStart / 16
+			yStart := motionBlocking.Get(heightmapIndex) + cache.minY
 
-			section := chunk.Sections[sectionY]
-			if len(section.Biomes.Palette) == 0 {
+			sc := cache.getBlock(yStart)
+			if sc == nil || len(sc.section.Biomes.Palette) == 0 {
 				continue
 			}
+			biomes[heightmapIndex] = sc.biome(x, yStart, z)
+		}
+	}
 
-			v := calcBitsPerValue(16*16*16, len(section.Biomes.Data))
-			biomes := level.NewBitStorage(v, 16*16*16, section.Biomes.Data)
-
-			blockIndex := ((((sectionY) * 16) + z) * 16) + x
-			biomeState := section.Biomes.Palette[biomes.Get(blockIndex)]
+	colors := make(map[save.BiomeState]color.Color)
+	c.lock.Lock()
+	for _, biome := range biomes {
+		if _, ok := colors[biome]; !ok && biome != "" {
+			colors[biome] = c.biomeColor(string(biome))
+		}
+	}
+	c.lock.Unlock()
 
-			color := c.biomes[string(biomeState)]
-			if color != nil {
-				img.Set(x, z, color)
-			} else {
-				log.Printf("unmapped biome %v", biomeState)
-			}
+	for idx, biome := range biomes {
+		if clr, ok := colors[biome]; ok {
+			img.Set(idx%16, idx/16, clr)
 		}
 	}
 
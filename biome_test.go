@@ -0,0 +1,54 @@
+package carto
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/Tnze/go-mc/save"
+)
+
+func TestBiomeRenderChunk(t *testing.T) {
+	chunk := testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", 62: "minecraft:stone"}, "minecraft:plains")
+
+	// the surface is in the top cell of the section, the cells below it are
+	// all swamp so reading the wrong layer shows up
+	palette := []save.BiomeState{"minecraft:swamp", "minecraft:plains", "minecraft:desert"}
+	cell := func(x, y, z int) int {
+		if y != 3 {
+			return 0
+		}
+		return 1 + (x+z)%2
+	}
+	for idx := range chunk.Sections {
+		if chunk.Sections[idx].Y == 3 {
+			setTestBiomes(&chunk.Sections[idx], palette, 2, cell)
+		}
+	}
+
+	renderer, err := NewBiomeRenderer(NewChunkRenderOpts(map[string]string{"color.desert": "#123456"}), testPalette(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := renderer.RenderChunk(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	colors := map[save.BiomeState]color.Color{
+		"minecraft:plains": biomeColors["minecraft:plains"],
+		"minecraft:desert": color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff},
+	}
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			want := color.NRGBAModel.Convert(colors[palette[cell(x/4, 3, z/4)]])
+			if got := color.NRGBAModel.Convert(img.At(x, z)); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, z, got, want)
+			}
+		}
+	}
+
+	if missing := renderer.GetMissingBiomes(); len(missing) != 1 || missing[0] != "minecraft:desert" {
+		t.Errorf("missing biomes = %v, want [minecraft:desert]", missing)
+	}
+}
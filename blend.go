@@ -0,0 +1,160 @@
+package carto
+
+import (
+	"image/color"
+	"image/draw"
+
+	"github.com/Tnze/go-mc/save"
+)
+
+// blendColumn is what the blender needs to re-tint a single pixel
+type blendColumn struct {
+	kind tintKind
+
+	// water columns are darkened by their depth, see ChunkPixelRenderer
+	darkened bool
+	depth    uint8
+}
+
+// ChunkBiomeBlender averages grass, foliage and water tints over neighboring
+// columns for the ChunkPixelRenderer, like the clients biome blend setting
+type ChunkBiomeBlender struct {
+	radius  int
+	palette *Palette
+}
+
+func NewChunkBiomeBlender(palette *Palette, radius int) *ChunkBiomeBlender {
+	return &ChunkBiomeBlender{
+		radius:  radius,
+		palette: palette,
+	}
+}
+
+// tintSums is a summed-area table of one tint kind over a region and the
+// columns around it
+type tintSums struct {
+	size int
+	r    []float64
+	g    []float64
+	b    []float64
+	n    []float64
+}
+
+func newTintSums(size int) *tintSums {
+	cells := (size + 1) * (size + 1)
+	return &tintSums{
+		size: size,
+		r:    make([]float64, cells),
+		g:    make([]float64, cells),
+		b:    make([]float64, cells),
+		n:    make([]float64, cells),
+	}
+}
+
+// average returns the mean color of the cells from (x0, z0) to (x1, z1) exclusive
+func (t *tintSums) average(x0, z0, x1, z1 int) (color.Color, bool) {
+	stride := t.size + 1
+	sum := func(values []float64) float64 {
+		return values[z1*stride+x1] - values[z0*stride+x1] - values[z1*stride+x0] + values[z0*stride+x0]
+	}
+
+	n := sum(t.n)
+	if n == 0 {
+		return nil, false
+	}
+	return color.RGBA{
+		R: uint8(sum(t.r) / n),
+		G: uint8(sum(t.g) / n),
+		B: uint8(sum(t.b) / n),
+		A: 255,
+	}, true
+}
+
+// blendRegion re-tints the blended pixels of the given chunks within a region
+// image, chunks are their positions within the region
+func (b *ChunkBiomeBlender) blendRegion(img draw.Image, n *regionNeighborhood, chunks []coord) {
+	radius := b.radius
+	size := 32*16 + radius*2
+
+	// the biome of every column within radius of the region, biomes are
+	// interned so each kind of tint is only looked up once per biome
+	names := []save.BiomeState{""}
+	ids := make(map[save.BiomeState]uint16)
+	biomes := make([]uint16, size*size)
+	for z := 0; z < size; z++ {
+		for x := 0; x < size; x++ {
+			biome := n.biome(x-radius, z-radius)
+			if biome == "" {
+				continue
+			}
+
+			id, ok := ids[biome]
+			if !ok {
+				id = uint16(len(names))
+				names = append(names, biome)
+				ids[biome] = id
+			}
+			biomes[z*size+x] = id
+		}
+	}
+
+	sums := make(map[tintKind]*tintSums)
+	sumsFor := func(kind tintKind) *tintSums {
+		if t, ok := sums[kind]; ok {
+			return t
+		}
+
+		tints := make([]color.Color, len(names))
+		for id, name := range names {
+			if id != 0 {
+				tints[id] = b.palette.tintColor(kind, name)
+			}
+		}
+
+		t := newTintSums(size)
+		stride := size + 1
+		for z := 0; z < size; z++ {
+			for x := 0; x < size; x++ {
+				var r, g, bl, n float64
+				if tint := tints[biomes[z*size+x]]; tint != nil {
+					tr, tg, tb, _ := tint.RGBA()
+					r, g, bl, n = float64(tr>>8), float64(tg>>8), float64(tb>>8), 1
+				}
+
+				idx := (z+1)*stride + x + 1
+				above, left, corner := z*stride+x+1, (z+1)*stride+x, z*stride+x
+				t.r[idx] = r + t.r[above] + t.r[left] - t.r[corner]
+				t.g[idx] = g + t.g[above] + t.g[left] - t.g[corner]
+				t.b[idx] = bl + t.b[above] + t.b[left] - t.b[corner]
+				t.n[idx] = n + t.n[above] + t.n[left] - t.n[corner]
+			}
+		}
+
+		sums[kind] = t
+		return t
+	}
+
+	for _, crd := range chunks {
+		for idx := 0; idx < 256; idx++ {
+			column := n.center.blendColumn(crd, idx)
+			if column.kind == tintNone || column.kind == tintFixed {
+				continue
+			}
+
+			x, z := crd.X*16+idx%16, crd.Z*16+idx/16
+			if biomes[(z+radius)*size+x+radius] == 0 {
+				continue
+			}
+
+			clr, ok := sumsFor(column.kind).average(x, z, x+radius*2+1, z+radius*2+1)
+			if !ok {
+				continue
+			}
+
+			if column.darkened {
+				clr = combineColor(clr, color.RGBA{A: column.depth})
+			}
+			img.Set(x, z, clr)
+		}
+	}
+}
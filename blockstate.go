@@ -0,0 +1,302 @@
+package carto
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Tnze/go-mc/nbt"
+)
+
+type BlockStateMultipart struct {
+	Apply json.RawMessage `json:"apply"`
+	When  json.RawMessage `json:"when"`
+}
+
+type BlockStateVariant struct {
+	Model  string `json:"model"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	UVLock bool   `json:"uvlock"`
+	Weight int    `json:"weight"`
+}
+
+type BlockStateInfo struct {
+	Variants  map[string]json.RawMessage `json:"variants"`
+	Multipart []BlockStateMultipart      `json:"multipart"`
+}
+
+// Resolve returns the models used to draw a block with the given properties.
+// Variants resolve to a single model while multipart blocks return one model
+// for each part whose condition matches.
+func (b *BlockStateInfo) Resolve(properties map[string]string) ([]BlockStateVariant, error) {
+	if b.Multipart != nil {
+		return resolveMultipart(properties, b.Multipart)
+	}
+
+	variant, err := resolveVariant(properties, b.Variants)
+	if err != nil {
+		return nil, err
+	}
+	return []BlockStateVariant{variant}, nil
+}
+
+// resolveVariant picks the variant whose key matches the properties with the
+// most conditions, ties are broken by key so the result is deterministic
+func resolveVariant(properties map[string]string, variants map[string]json.RawMessage) (BlockStateVariant, error) {
+	bestKey := ""
+	bestConditions := -1
+	for key := range variants {
+		conditions := parseVariantProperties(key)
+
+		matches := true
+		for k, v := range conditions {
+			if properties[k] != v {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		if len(conditions) > bestConditions || (len(conditions) == bestConditions && key < bestKey) {
+			bestKey = key
+			bestConditions = len(conditions)
+		}
+	}
+
+	if bestConditions == -1 {
+		return BlockStateVariant{}, fmt.Errorf("no variant matches %s", formatProperties(properties))
+	}
+
+	options, err := decodeVariants(variants[bestKey])
+	if err != nil {
+		return BlockStateVariant{}, fmt.Errorf("variant %q: %v", bestKey, err)
+	}
+	return options[0], nil
+}
+
+// resolveMultipart returns the first model of every part which applies
+func resolveMultipart(properties map[string]string, parts []BlockStateMultipart) ([]BlockStateVariant, error) {
+	result := []BlockStateVariant{}
+	for idx, part := range parts {
+		if len(part.When) > 0 {
+			matches, err := matchesCondition(properties, part.When)
+			if err != nil {
+				return nil, fmt.Errorf("multipart %d: %v", idx, err)
+			}
+
+			if !matches {
+				continue
+			}
+		}
+
+		options, err := decodeVariants(part.Apply)
+		if err != nil {
+			return nil, fmt.Errorf("multipart %d: %v", idx, err)
+		}
+		result = append(result, options[0])
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no multipart applies to %s", formatProperties(properties))
+	}
+	return result, nil
+}
+
+// matchesCondition evaluates a multipart when clause. A clause is either an
+// OR or AND of nested clauses, or a set of properties which must all match one
+// of their | separated values.
+func matchesCondition(properties map[string]string, raw json.RawMessage) (bool, error) {
+	var condition map[string]json.RawMessage
+	err := json.Unmarshal(raw, &condition)
+	if err != nil {
+		return false, fmt.Errorf("invalid condition %s", string(raw))
+	}
+
+	if nested, ok := condition["OR"]; ok {
+		var clauses []json.RawMessage
+		if err := json.Unmarshal(nested, &clauses); err != nil {
+			return false, fmt.Errorf("invalid OR condition %s", string(nested))
+		}
+
+		for _, clause := range clauses {
+			matches, err := matchesCondition(properties, clause)
+			if err != nil {
+				return false, err
+			} else if matches {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if nested, ok := condition["AND"]; ok {
+		var clauses []json.RawMessage
+		if err := json.Unmarshal(nested, &clauses); err != nil {
+			return false, fmt.Errorf("invalid AND condition %s", string(nested))
+		}
+
+		for _, clause := range clauses {
+			matches, err := matchesCondition(properties, clause)
+			if err != nil {
+				return false, err
+			} else if !matches {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	for key, rawValue := range condition {
+		var value any
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			return false, fmt.Errorf("invalid value for %s in condition", key)
+		}
+
+		// values are usually strings, but some packs use bare booleans and numbers
+		allowed := strings.Split(fmt.Sprint(value), "|")
+
+		matches := false
+		for _, v := range allowed {
+			if properties[key] == v {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// multipartProperties returns properties under which at least one part of a
+// multipart block applies, blocks whose parts all have a condition use the
+// first values allowed by the first part
+func multipartProperties(parts []BlockStateMultipart) (map[string]string, error) {
+	if len(parts) == 0 || len(parts[0].When) == 0 {
+		return map[string]string{}, nil
+	}
+
+	for _, part := range parts {
+		if len(part.When) == 0 {
+			return map[string]string{}, nil
+		}
+	}
+
+	properties := make(map[string]string)
+	err := conditionProperties(properties, parts[0].When)
+	if err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
+
+// conditionProperties sets properties so they satisfy a when clause, using the
+// first clause of an OR and the first of several | separated values
+func conditionProperties(properties map[string]string, raw json.RawMessage) error {
+	var condition map[string]json.RawMessage
+	err := json.Unmarshal(raw, &condition)
+	if err != nil {
+		return fmt.Errorf("invalid condition %s", string(raw))
+	}
+
+	for _, op := range []string{"OR", "AND"} {
+		nested, ok := condition[op]
+		if !ok {
+			continue
+		}
+
+		var clauses []json.RawMessage
+		if err := json.Unmarshal(nested, &clauses); err != nil || len(clauses) == 0 {
+			return fmt.Errorf("invalid %s condition %s", op, string(nested))
+		}
+
+		if op == "OR" {
+			clauses = clauses[:1]
+		}
+		for _, clause := range clauses {
+			if err := conditionProperties(properties, clause); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for key, rawValue := range condition {
+		var value any
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			return fmt.Errorf("invalid value for %s in condition", key)
+		}
+
+		first, _, _ := strings.Cut(fmt.Sprint(value), "|")
+		properties[key] = first
+	}
+	return nil
+}
+
+// decodeVariants decodes a single variant or a weighted list of variants
+func decodeVariants(raw json.RawMessage) ([]BlockStateVariant, error) {
+	var variants []BlockStateVariant
+	err := json.Unmarshal(raw, &variants)
+	if err != nil {
+		var v BlockStateVariant
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("invalid variant %s", string(raw))
+		}
+		variants = append(variants, v)
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("empty variant list")
+	}
+	return variants, nil
+}
+
+// parseVariantProperties parses a variant key such as facing=north,lit=true,
+// the empty key matches every state
+func parseVariantProperties(raw string) map[string]string {
+	result := make(map[string]string)
+	if raw == "" {
+		return result
+	}
+
+	for _, part := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+func formatProperties(properties map[string]string) string {
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+properties[k])
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+func makeStatePropertiesMap(msg nbt.RawMessage) (map[string]string, error) {
+	test := map[string]string{}
+	if msg.Type == nbt.TagEnd {
+		return test, nil
+	}
+
+	err := msg.Unmarshal(&test)
+	if err != nil {
+		return nil, err
+	}
+	return test, nil
+}
@@ -0,0 +1,188 @@
+package carto
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadTestBlockState(t *testing.T, name string) BlockStateInfo {
+	data, err := os.ReadFile(filepath.Join("testdata", "blockstates", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info BlockStateInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestBlockStateResolve(t *testing.T) {
+	fenceSide := func(y int) BlockStateVariant {
+		return BlockStateVariant{Model: "minecraft:block/oak_fence_side", Y: y, UVLock: true}
+	}
+	fencePost := BlockStateVariant{Model: "minecraft:block/oak_fence_post"}
+	dot := BlockStateVariant{Model: "minecraft:block/redstone_dust_dot"}
+
+	tests := []struct {
+		name       string
+		block      string
+		properties map[string]string
+		want       []BlockStateVariant
+		wantErr    bool
+	}{
+		{
+			name:       "fence post only",
+			block:      "oak_fence",
+			properties: map[string]string{"north": "false", "east": "false", "south": "false", "west": "false"},
+			want:       []BlockStateVariant{fencePost},
+		},
+		{
+			name:       "fence sides keep their rotation",
+			block:      "oak_fence",
+			properties: map[string]string{"north": "true", "east": "false", "south": "true", "west": "true"},
+			want:       []BlockStateVariant{fencePost, fenceSide(0), fenceSide(180), fenceSide(270)},
+		},
+		{
+			name:       "redstone dot when unconnected",
+			block:      "redstone_wire",
+			properties: map[string]string{"north": "none", "east": "none", "south": "none", "west": "none"},
+			want:       []BlockStateVariant{dot},
+		},
+		{
+			name:       "redstone OR clause with a bend",
+			block:      "redstone_wire",
+			properties: map[string]string{"north": "up", "east": "side", "south": "none", "west": "none"},
+			want: []BlockStateVariant{
+				dot,
+				{Model: "minecraft:block/redstone_dust_side0"},
+				{Model: "minecraft:block/redstone_dust_side_alt1", Y: 270},
+				{Model: "minecraft:block/redstone_dust_up"},
+			},
+		},
+		{
+			name:       "redstone OR clause without a match",
+			block:      "redstone_wire",
+			properties: map[string]string{"north": "side", "east": "none", "south": "side", "west": "none"},
+			want: []BlockStateVariant{
+				{Model: "minecraft:block/redstone_dust_side0"},
+				{Model: "minecraft:block/redstone_dust_side_alt0"},
+			},
+		},
+		{
+			name:    "redstone without any part",
+			block:   "redstone_wire",
+			wantErr: true,
+		},
+		{
+			name:       "stairs unrotated",
+			block:      "oak_stairs",
+			properties: map[string]string{"facing": "east", "half": "bottom", "shape": "straight", "waterlogged": "false"},
+			want:       []BlockStateVariant{{Model: "minecraft:block/oak_stairs"}},
+		},
+		{
+			name:       "stairs rotated",
+			block:      "oak_stairs",
+			properties: map[string]string{"facing": "west", "half": "bottom", "shape": "straight", "waterlogged": "true"},
+			want:       []BlockStateVariant{{Model: "minecraft:block/oak_stairs", Y: 180, UVLock: true}},
+		},
+		{
+			name:       "stairs upside down and rotated",
+			block:      "oak_stairs",
+			properties: map[string]string{"facing": "south", "half": "top", "shape": "outer_right", "waterlogged": "false"},
+			want:       []BlockStateVariant{{Model: "minecraft:block/oak_stairs_outer", X: 180, Y: 180, UVLock: true}},
+		},
+		{
+			name:       "stairs without a variant",
+			block:      "oak_stairs",
+			properties: map[string]string{"facing": "north", "half": "top", "shape": "outer_left"},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := loadTestBlockState(t, test.block)
+
+			got, err := info.Resolve(test.properties)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Resolve = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Resolve = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchesCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		condition  string
+		properties map[string]string
+		want       bool
+	}{
+		{"single value", `{"north": "true"}`, map[string]string{"north": "true"}, true},
+		{"single value mismatch", `{"north": "true"}`, map[string]string{"north": "false"}, false},
+		{"missing property", `{"north": "true"}`, map[string]string{}, false},
+		{"alternatives", `{"north": "side|up"}`, map[string]string{"north": "up"}, true},
+		{"every property", `{"north": "up", "east": "side"}`, map[string]string{"north": "up", "east": "none"}, false},
+		{"bare boolean", `{"north": true}`, map[string]string{"north": "true"}, true},
+		{"OR", `{"OR": [{"north": "up"}, {"east": "up"}]}`, map[string]string{"north": "none", "east": "up"}, true},
+		{"OR mismatch", `{"OR": [{"north": "up"}, {"east": "up"}]}`, map[string]string{"north": "none", "east": "none"}, false},
+		{"AND", `{"AND": [{"north": "up"}, {"east": "side|up"}]}`, map[string]string{"north": "up", "east": "side"}, true},
+		{"AND mismatch", `{"AND": [{"north": "up"}, {"east": "up"}]}`, map[string]string{"north": "up", "east": "none"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := matchesCondition(test.properties, json.RawMessage(test.condition))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("matchesCondition(%s) = %v, want %v", test.condition, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMultipartProperties(t *testing.T) {
+	tests := []struct {
+		block string
+		want  []BlockStateVariant
+	}{
+		{"oak_fence", []BlockStateVariant{{Model: "minecraft:block/oak_fence_post"}}},
+		{"redstone_wire", []BlockStateVariant{{Model: "minecraft:block/redstone_dust_dot"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.block, func(t *testing.T) {
+			info := loadTestBlockState(t, test.block)
+
+			properties, err := multipartProperties(info.Multipart)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := info.Resolve(properties)
+			if err != nil {
+				t.Fatalf("Resolve(%v): %v", properties, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Resolve(%v) = %+v, want %+v", properties, got, test.want)
+			}
+		})
+	}
+}
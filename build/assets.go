@@ -0,0 +1,129 @@
+package build
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Tnze/go-mc/save"
+	"github.com/b1naryth1ef/carto"
+)
+
+// LoadMapAssets creates the asset loader for a map. The client JAR for the
+// world's version is downloaded into the output's res directory unless the map
+// uses a precomputed palette file, resource packs are stacked on top.
+func LoadMapAssets(mapCfg *carto.MapConfigBlock, outputPath string) (*carto.AssetLoader, error) {
+	overrides, err := mapCfg.ColorOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	var assetLoader *carto.AssetLoader
+	if mapCfg.Palette != nil && mapCfg.Palette.File != "" {
+		precomputed, err := carto.LoadPaletteFile(mapCfg.Palette.File)
+		if err != nil {
+			return nil, fmt.Errorf("map %s: %v", mapCfg.Name, err)
+		}
+
+		assetLoader = carto.NewAssetLoader()
+		assetLoader.Precomputed = precomputed
+	} else {
+		clientJarPath, err := clientJarForMap(mapCfg, outputPath)
+		if err != nil {
+			return nil, err
+		}
+
+		assetLoader, err = carto.NewAssetLoaderFromClientJAR(clientJarPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	assetLoader.ColorOverrides = overrides
+
+	// the world's datapacks define its custom biomes, they sit below the
+	// resource packs which only carry assets
+	err = addWorldDatapacks(assetLoader, mapCfg.WorldPath())
+	if err != nil {
+		assetLoader.Close()
+		return nil, fmt.Errorf("map %s: %v", mapCfg.Name, err)
+	}
+
+	// packs are added lowest priority first so the first listed pack wins
+	for i := len(mapCfg.ResourcePacks) - 1; i >= 0; i-- {
+		err = assetLoader.AddPack(mapCfg.ResourcePacks[i])
+		if err != nil {
+			assetLoader.Close()
+			return nil, fmt.Errorf("map %s: failed to load resource pack %s: %v", mapCfg.Name, mapCfg.ResourcePacks[i], err)
+		}
+	}
+
+	return assetLoader, nil
+}
+
+// addWorldDatapacks adds the zipped and unpacked datapacks of a world in name
+// order, worlds without a datapacks directory have none
+func addWorldDatapacks(assetLoader *carto.AssetLoader, worldPath string) error {
+	entries, err := os.ReadDir(filepath.Join(worldPath, "datapacks"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list datapacks: %v", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.EqualFold(filepath.Ext(entry.Name()), ".zip") {
+			continue
+		}
+
+		path := filepath.Join(worldPath, "datapacks", entry.Name())
+		err = assetLoader.AddPack(path)
+		if err != nil {
+			return fmt.Errorf("failed to load datapack %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// clientJarForMap returns the path of the client JAR matching the map's
+// version, reading it from level.dat if it is not configured
+func clientJarForMap(mapCfg *carto.MapConfigBlock, outputPath string) (string, error) {
+	version := mapCfg.Version
+	if version == "" {
+		levelPath := filepath.Join(mapCfg.WorldPath(), "level.dat")
+
+		fd, err := os.Open(levelPath)
+		if err != nil {
+			return "", err
+		}
+
+		r, err := gzip.NewReader(fd)
+		if err != nil {
+			fd.Close()
+			return "", err
+		}
+
+		level, err := save.ReadLevel(r)
+		fd.Close()
+		if err != nil {
+			return "", err
+		}
+
+		version = level.Data.Version.Name
+	}
+
+	clientJarPath := filepath.Join(outputPath, "res", fmt.Sprintf("client-%s.jar", version))
+	if _, err := os.Stat(clientJarPath); os.IsNotExist(err) {
+		err = ensureDirectory(filepath.Dir(clientJarPath))
+		if err != nil {
+			return "", err
+		}
+
+		err = downloadClientJar(version, clientJarPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	return clientJarPath, nil
+}
@@ -1,17 +1,12 @@
 package build
 
 import (
-	"compress/gzip"
 	"embed"
 	"encoding/json"
-	"fmt"
 	"html/template"
-	"log"
 	"os"
 	"path/filepath"
-	"time"
 
-	"github.com/Tnze/go-mc/save"
 	"github.com/b1naryth1ef/carto"
 	"github.com/b1naryth1ef/carto/dl"
 	"github.com/b1naryth1ef/carto/web"
@@ -134,172 +129,12 @@ func downloadClientJar(v string, path string) error {
 	return meta.Downloads["client"].Get(out)
 }
 
-func buildMap(config *carto.Config, opts BuildOpts, mapCfg *carto.MapConfigBlock, layers map[string]*carto.LayerConfigBlock, outputPath string) (*web.MapData, error) {
-	tilePath := filepath.Join(outputPath, "tiles", mapCfg.Name)
-	err := ensureDirectory(tilePath)
-	if err != nil {
-		return nil, err
-	}
-
-	version := mapCfg.Version
-	if version == "" {
-		levelPath := filepath.Join(mapCfg.Path, "..", "level.dat")
-
-		fd, err := os.Open(levelPath)
-		if err != nil {
-			return nil, err
-		}
-
-		r, err := gzip.NewReader(fd)
-		if err != nil {
-			return nil, err
-		}
-
-		level, err := save.ReadLevel(r)
-		fd.Close()
-		if err != nil {
-			return nil, err
-		}
-
-		version = level.Data.Version.Name
-	}
-
-	clientJarPath := filepath.Join(outputPath, "res", fmt.Sprintf("client-%s.jar", version))
-	if _, err := os.Stat(clientJarPath); os.IsNotExist(err) {
-		err = downloadClientJar(version, clientJarPath)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	assetLoader, err := carto.NewAssetLoaderFromClientJAR(clientJarPath)
-	if err != nil {
-		return nil, err
-	}
-	defer assetLoader.Close()
-
-	mapData := web.MapData{
-		Name:   mapCfg.Name,
-		Layers: []web.LayerData{},
-	}
-
-	for _, layerName := range mapCfg.Layers {
-		layerCfg := layers[layerName]
-
-		mapData.Layers = append(mapData.Layers, web.LayerData{
-			Name:     layerName,
-			TileSize: 512,
-			Opacity:  layerCfg.Opacity,
-		})
-
-		err := ensureDirectory(filepath.Join(tilePath, layerName))
-		if err != nil {
-			return nil, err
-		}
-
-		renderOpts := carto.WorldRenderOpts{
-			Concurrency: config.Concurrency,
-		}
-
-		var buildMeta carto.RenderMeta
-		buildMetaPath := filepath.Join(tilePath, layerName, "build.json")
-
-		if !opts.ForceClean {
-			if _, err := os.Stat(filepath.Join(tilePath, layerName, "build.json")); err == nil {
-				data, err := os.ReadFile(buildMetaPath)
-				if err != nil {
-					return nil, err
-				}
-
-				err = json.Unmarshal(data, &buildMeta)
-				if err != nil {
-					return nil, err
-				}
-
-				renderOpts.RegionTimestamps = buildMeta.RegionTimestamps
-			}
-		}
-
-		opts := carto.NewChunkRenderOpts(layerCfg.Options)
-
-		var chunkRenderer carto.ChunkRenderer
-		if layerCfg.Render == "pixel" {
-			chunkRenderer = carto.NewChunkPixelRenderer(opts, assetLoader)
-		} else if layerCfg.Render == "biome" {
-			chunkRenderer = carto.NewBiomeRenderer(assetLoader)
-		} else if layerCfg.Render == "light" {
-			chunkRenderer = carto.NewLightingRenderer()
-		} else {
-			log.Panicf("Unsupported renderer '%s'", layerCfg.Render)
-		}
-
-		renderer := carto.NewRenderer(chunkRenderer)
-
-		start := time.Now()
-		result, err := renderer.RenderWorld(mapCfg.Path, filepath.Join(tilePath, layerName), renderOpts)
-		if err != nil {
-			return nil, err
-		}
-
-		buildMeta.RegionTimestamps = result.RegionTimestamps
-		data, err := json.Marshal(buildMeta)
-		if err != nil {
-			return nil, err
-		}
-
-		os.WriteFile(buildMetaPath, data, os.ModePerm)
-
-		log.Printf("Finished rendering %s/%s in %dms (%d chunks)", mapCfg.Name, layerName, time.Since(start).Milliseconds(), result.RenderedChunks)
-	}
-
-	return &mapData, nil
-}
-
 func Build(config *carto.Config, opts BuildOpts) error {
-	outputs := map[string]string{}
-	for _, output := range config.Outputs {
-		err := ensureDirectory(output.Path)
-		if err != nil {
-			return err
-		}
-
-		err = ensureDirectory(filepath.Join(output.Path, "tiles"))
-		if err != nil {
-			return err
-		}
-
-		err = ensureDirectory(filepath.Join(output.Path, "res"))
-		if err != nil {
-			return err
-		}
-
-		outputs[output.Name] = output.Path
-	}
-
-	layers := map[string]*carto.LayerConfigBlock{}
-	for _, layer := range config.Layers {
-		layers[layer.Name] = layer
-	}
-
-	maps := []web.MapData{}
-	for _, mapCfg := range config.Maps {
-		mapData, err := buildMap(config, opts, mapCfg, layers, outputs[mapCfg.Output])
-		if err != nil {
-			return err
-		}
-		maps = append(maps, *mapData)
-	}
-
-	for _, output := range config.Outputs {
-		if output.IncludeStatic {
-			err := writeStatic(output.Path, web.FrontendData{
-				Maps: maps,
-			})
-			if err != nil {
-				return err
-			}
-		}
+	session, err := NewSession(config, opts)
+	if err != nil {
+		return err
 	}
+	defer session.Close()
 
-	return nil
+	return session.Build()
 }
@@ -0,0 +1,438 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/b1naryth1ef/carto"
+	"github.com/b1naryth1ef/carto/web"
+)
+
+// Session holds the asset loaders and renderers for a config so they stay warm
+// across multiple builds
+type Session struct {
+	config *carto.Config
+	maps   []*mapSession
+}
+
+type mapSession struct {
+	cfg         *carto.MapConfigBlock
+	regionPath  string
+	tilePath    string
+	area        *carto.RenderArea
+	assetLoader *carto.AssetLoader
+	layers      []*layerSession
+
+	markerMeta *carto.MarkerMeta
+}
+
+type layerSession struct {
+	name       string
+	path       string
+	zoomLevels int
+	chunk      carto.ChunkRenderer
+	renderer   *carto.Renderer
+	meta       carto.RenderMeta
+	data       web.LayerData
+
+	// clean is set until the first render of a clean build
+	clean bool
+}
+
+// NewSession prepares the outputs, asset loaders and renderers for every map in
+// a config. Previous build metadata is loaded unless a clean build is requested.
+func NewSession(config *carto.Config, opts BuildOpts) (*Session, error) {
+	outputs := map[string]string{}
+	for _, output := range config.Outputs {
+		err := ensureDirectory(output.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ensureDirectory(filepath.Join(output.Path, "tiles"))
+		if err != nil {
+			return nil, err
+		}
+
+		err = ensureDirectory(filepath.Join(output.Path, "res"))
+		if err != nil {
+			return nil, err
+		}
+
+		outputs[output.Name] = output.Path
+	}
+
+	layers := map[string]*carto.LayerConfigBlock{}
+	for _, layer := range config.Layers {
+		layers[layer.Name] = layer
+	}
+
+	session := &Session{
+		config: config,
+	}
+
+	for _, mapCfg := range config.Maps {
+		m, err := newMapSession(config, opts, mapCfg, layers, outputs[mapCfg.Output])
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+		session.maps = append(session.maps, m)
+	}
+
+	return session, nil
+}
+
+func newMapSession(config *carto.Config, opts BuildOpts, mapCfg *carto.MapConfigBlock, layers map[string]*carto.LayerConfigBlock, outputPath string) (*mapSession, error) {
+	tilePath := filepath.Join(outputPath, "tiles", mapCfg.Name)
+	err := ensureDirectory(tilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	regionPath, err := mapCfg.RegionPath()
+	if err != nil {
+		return nil, err
+	}
+
+	area, err := mapCfg.Area()
+	if err != nil {
+		return nil, err
+	}
+
+	assetLoader, err := LoadMapAssets(mapCfg, outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mapSession{
+		cfg:         mapCfg,
+		regionPath:  regionPath,
+		tilePath:    tilePath,
+		area:        area,
+		assetLoader: assetLoader,
+	}
+
+	markerMetaPath := filepath.Join(tilePath, "markers-build.json")
+	if !opts.ForceClean {
+		if data, err := os.ReadFile(markerMetaPath); err == nil {
+			err = json.Unmarshal(data, &m.markerMeta)
+			if err != nil {
+				assetLoader.Close()
+				return nil, err
+			}
+		}
+	}
+
+	for _, layerName := range mapCfg.Layers {
+		layerCfg := layers[layerName]
+
+		zoomLevels := layerCfg.ZoomLevels
+		if zoomLevels == 0 {
+			zoomLevels = carto.DefaultZoomLevels
+		}
+
+		layerPath := filepath.Join(tilePath, layerName)
+		err := ensureDirectory(layerPath)
+		if err != nil {
+			assetLoader.Close()
+			return nil, err
+		}
+
+		var buildMeta carto.RenderMeta
+		buildMetaPath := filepath.Join(layerPath, "build.json")
+
+		if !opts.ForceClean {
+			if _, err := os.Stat(buildMetaPath); err == nil {
+				data, err := os.ReadFile(buildMetaPath)
+				if err != nil {
+					assetLoader.Close()
+					return nil, err
+				}
+
+				err = json.Unmarshal(data, &buildMeta)
+				if err != nil {
+					assetLoader.Close()
+					return nil, err
+				}
+			}
+		}
+
+		renderOpts := carto.NewChunkRenderOpts(mapCfg.LayerOptions(layerCfg))
+
+		chunkRenderer, err := carto.NewChunkRenderer(layerCfg.Render, renderOpts, assetLoader)
+		if err != nil {
+			assetLoader.Close()
+			return nil, fmt.Errorf("failed to create renderer for layer %s: %v", layerName, err)
+		}
+
+		m.layers = append(m.layers, &layerSession{
+			name:       layerName,
+			path:       layerPath,
+			zoomLevels: zoomLevels,
+			chunk:      chunkRenderer,
+			renderer:   carto.NewRenderer(chunkRenderer),
+			meta:       buildMeta,
+			clean:      opts.ForceClean,
+			data: web.LayerData{
+				Name:          layerName,
+				TileSize:      512,
+				Opacity:       layerCfg.Opacity,
+				MinNativeZoom: carto.RegionTileZoom - zoomLevels,
+				MaxNativeZoom: carto.RegionTileZoom,
+				Projection:    carto.GetProjection(chunkRenderer),
+				Legend:        carto.GetLegendFile(chunkRenderer),
+			},
+		})
+	}
+
+	return m, nil
+}
+
+// Build renders every map and writes the static frontend for each output
+func (s *Session) Build() error {
+	maps := []web.MapData{}
+	for _, m := range s.maps {
+		mapData := web.MapData{
+			Name:   m.cfg.Name,
+			Layers: []web.LayerData{},
+		}
+		if extent := m.area.Extent(); extent != nil {
+			mapData.Bounds = &web.BoundsData{MinX: extent.MinX, MinZ: extent.MinZ, MaxX: extent.MaxX, MaxZ: extent.MaxZ}
+		}
+
+		for _, layer := range m.layers {
+			err := layer.render(s.config, m, nil)
+			if err != nil {
+				return err
+			}
+			mapData.Layers = append(mapData.Layers, layer.data)
+		}
+
+		hasMarkers, err := m.writeMarkers()
+		if err != nil {
+			return err
+		}
+		mapData.Markers = hasMarkers
+
+		maps = append(maps, mapData)
+	}
+
+	for _, output := range s.config.Outputs {
+		if output.IncludeStatic {
+			err := writeStatic(output.Path, web.FrontendData{
+				Maps: maps,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RenderRegions renders only the named region files for a map across all of its layers
+func (s *Session) RenderRegions(mapName string, regionFiles []string) error {
+	for _, m := range s.maps {
+		if m.cfg.Name != mapName {
+			continue
+		}
+
+		for _, layer := range m.layers {
+			err := layer.render(s.config, m, regionFiles)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := m.writeMarkers()
+		return err
+	}
+
+	return fmt.Errorf("unknown map '%s'", mapName)
+}
+
+// RegionPaths returns the region directory for each map by name
+func (s *Session) RegionPaths() map[string]string {
+	result := make(map[string]string)
+	for _, m := range s.maps {
+		result[m.cfg.Name] = m.regionPath
+	}
+	return result
+}
+
+func (s *Session) Close() {
+	for _, m := range s.maps {
+		m.assetLoader.Close()
+	}
+}
+
+// writeMarkers extracts the configured markers for a map into markers.json,
+// returning false if the map does not publish any markers
+func (m *mapSession) writeMarkers() (bool, error) {
+	types := m.cfg.MarkerTypes()
+
+	// markers are placed in block coordinates which only line up with top-down layers
+	if len(types) == 0 || len(m.layers) == 0 || m.layers[0].data.Projection != "topdown" {
+		return false, nil
+	}
+
+	start := time.Now()
+
+	markers, meta, err := carto.ExtractMarkers(m.regionPath, carto.MarkerOpts{
+		Types:     types,
+		WorldPath: m.cfg.WorldPath(),
+		Dimension: m.cfg.DimensionID(),
+		Area:      m.area,
+		Previous:  m.markerMeta,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to extract markers for %s: %v", m.cfg.Name, err)
+	}
+	m.markerMeta = meta
+
+	data, err := json.Marshal(markers)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.WriteFile(filepath.Join(m.tilePath, "markers.json"), data, os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+
+	data, err = json.Marshal(meta)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.WriteFile(filepath.Join(m.tilePath, "markers-build.json"), data, os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Extracted %d markers for %s in %dms", len(markers), m.cfg.Name, time.Since(start).Milliseconds())
+	return true, nil
+}
+
+// render renders a layer, when regionFiles is nil the whole world is rendered
+func (l *layerSession) render(config *carto.Config, m *mapSession, regionFiles []string) error {
+	renderOpts := carto.WorldRenderOpts{
+		Concurrency:      config.Concurrency,
+		ZoomLevels:       l.zoomLevels,
+		ChunkTimestamps:  l.meta.ChunkTimestamps,
+		RegionTimestamps: l.meta.RegionTimestamps,
+
+		// only regenerate the whole pyramid if the zoom levels have changed
+		RebuildTiles: l.meta.ZoomLevels != l.zoomLevels,
+
+		Area:  m.area,
+		Clean: l.clean,
+	}
+
+	start := time.Now()
+
+	var result *carto.WorldRenderResult
+	var err error
+	if regionFiles == nil {
+		result, err = l.renderer.RenderWorld(m.regionPath, l.path, renderOpts)
+	} else {
+		result, err = l.renderer.RenderRegionFiles(m.regionPath, l.path, regionFiles, renderOpts)
+	}
+	if err != nil {
+		return err
+	}
+	l.clean = false
+
+	if regionFiles == nil || l.meta.ChunkTimestamps == nil {
+		l.meta.ChunkTimestamps = result.ChunkTimestamps
+	} else {
+		for regionName, timestamps := range result.ChunkTimestamps {
+			l.meta.ChunkTimestamps[regionName] = timestamps
+		}
+	}
+	l.meta.RegionTimestamps = nil
+	l.meta.ZoomLevels = l.zoomLevels
+
+	data, err := json.Marshal(l.meta)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(l.path, "build.json"), data, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// chunks which were skipped may still contain unresolved blocks from an earlier build
+	err = l.writeMissingAssets(result.SkippedChunks > 0 || regionFiles != nil)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Finished rendering %s/%s in %dms (%d chunks rendered, %d skipped)", m.cfg.Name, l.name, time.Since(start).Milliseconds(), result.RenderedChunks, result.SkippedChunks)
+	return nil
+}
+
+// MissingAssetsReport lists the block states and biomes a layer could not
+// resolve, written to unresolved.json next to build.json
+type MissingAssetsReport struct {
+	BlockStates []string `json:"blockStates"`
+	Biomes      []string `json:"biomes"`
+}
+
+func (l *layerSession) writeMissingAssets(merge bool) error {
+	reporter, ok := l.chunk.(carto.MissingAssetsReporter)
+	if !ok {
+		return nil
+	}
+
+	path := filepath.Join(l.path, "unresolved.json")
+
+	var previous MissingAssetsReport
+	if merge {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &previous)
+		}
+	}
+
+	report := MissingAssetsReport{
+		BlockStates: mergeSorted(previous.BlockStates, reporter.GetMissingBlockStates()),
+		Biomes:      mergeSorted(previous.Biomes, reporter.GetMissingBiomes()),
+	}
+
+	if len(report.BlockStates) > 0 || len(report.Biomes) > 0 {
+		log.Printf("Layer %s has %d unresolved block states and %d unresolved biomes, see %s", l.name, len(report.BlockStates), len(report.Biomes), path)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, os.ModePerm)
+}
+
+// mergeSorted returns the sorted union of two lists
+func mergeSorted(a, b []string) []string {
+	set := make(map[string]struct{})
+	for _, v := range a {
+		set[v] = struct{}{}
+	}
+	for _, v := range b {
+		set[v] = struct{}{}
+	}
+
+	result := make([]string, 0, len(set))
+	for v := range set {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
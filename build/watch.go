@@ -0,0 +1,142 @@
+package build
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/b1naryth1ef/carto"
+)
+
+type WatchOpts struct {
+	// Interval is how often region directories are checked for changes
+	Interval time.Duration
+
+	// Debounce is how long a region file must go unmodified before it is
+	// rendered, the server writes regions many times during a save-all
+	Debounce time.Duration
+
+	// OnRender is called after each successful render
+	OnRender func()
+}
+
+type pendingRegion struct {
+	modTime     time.Time
+	lastChanged time.Time
+}
+
+// Watch builds every map once and then keeps the renderers loaded, re-rendering
+// region files as they are saved. It only returns if the initial build fails.
+func Watch(config *carto.Config, opts WatchOpts) error {
+	session, err := NewSession(config, BuildOpts{})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	regionPaths := session.RegionPaths()
+
+	// snapshot modification times before building so saves during the build are picked up
+	known := make(map[string]map[string]time.Time)
+	for mapName, regionPath := range regionPaths {
+		known[mapName], err = scanRegionFiles(regionPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = session.Build()
+	if err != nil {
+		return err
+	}
+
+	if opts.OnRender != nil {
+		opts.OnRender()
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]map[string]*pendingRegion)
+	for mapName := range regionPaths {
+		pending[mapName] = make(map[string]*pendingRegion)
+	}
+
+	for now := range ticker.C {
+		rendered := false
+		for mapName, regionPath := range regionPaths {
+			files, err := scanRegionFiles(regionPath)
+			if err != nil {
+				log.Printf("[watch] failed to scan %s: %v", regionPath, err)
+				continue
+			}
+
+			for name, modTime := range files {
+				if p, ok := pending[mapName][name]; ok {
+					if !modTime.Equal(p.modTime) {
+						p.modTime = modTime
+						p.lastChanged = now
+					}
+				} else if !modTime.Equal(known[mapName][name]) {
+					pending[mapName][name] = &pendingRegion{modTime: modTime, lastChanged: now}
+				}
+			}
+
+			ready := []string{}
+			for name, p := range pending[mapName] {
+				if now.Sub(p.lastChanged) >= opts.Debounce {
+					ready = append(ready, name)
+				}
+			}
+
+			if len(ready) == 0 {
+				continue
+			}
+			sort.Strings(ready)
+
+			log.Printf("[watch] rendering %d changed regions for %s", len(ready), mapName)
+			err = session.RenderRegions(mapName, ready)
+			if err != nil {
+				log.Printf("[watch] failed to render %s: %v", mapName, err)
+				continue
+			}
+
+			for _, name := range ready {
+				known[mapName][name] = pending[mapName][name].modTime
+				delete(pending[mapName], name)
+			}
+			rendered = true
+		}
+
+		if rendered && opts.OnRender != nil {
+			opts.OnRender()
+		}
+	}
+
+	return nil
+}
+
+// scanRegionFiles returns the modification time of every region file in a directory
+func scanRegionFiles(path string) (map[string]time.Time, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]time.Time)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".mca") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// the file may have been replaced between listing and stat
+			continue
+		}
+		result[entry.Name()] = info.ModTime()
+	}
+	return result, nil
+}
@@ -2,6 +2,9 @@ package carto
 
 import (
 	"image"
+	"image/color"
+	"strconv"
+	"strings"
 
 	"github.com/Tnze/go-mc/save"
 )
@@ -12,6 +15,27 @@ type ChunkRenderer interface {
 	Finalize(path string) error
 }
 
+// MissingAssetsReporter is implemented by renderers which can report the block
+// states and biomes they were unable to resolve from the assets
+type MissingAssetsReporter interface {
+	GetMissingBlockStates() []string
+	GetMissingBiomes() []string
+}
+
+// LegendRenderer is implemented by renderers which write a legend of the colors
+// they use next to their tiles, as a json list of LegendEntry
+type LegendRenderer interface {
+	LegendFile() string
+}
+
+// GetLegendFile returns the legend written by a ChunkRenderer, if any
+func GetLegendFile(chunk ChunkRenderer) string {
+	if legend, ok := chunk.(LegendRenderer); ok {
+		return legend.LegendFile()
+	}
+	return ""
+}
+
 type ChunkRenderOpts struct {
 	data map[string]string
 }
@@ -32,3 +56,68 @@ func (c *ChunkRenderOpts) GetBool(key string, def bool) bool {
 
 	return false
 }
+
+func (c *ChunkRenderOpts) GetString(key string, def string) string {
+	v, ok := c.data[key]
+	if !ok {
+		return def
+	}
+	return v
+}
+
+func (c *ChunkRenderOpts) GetInt(key string, def int) int {
+	v, ok := c.data[key]
+	if !ok {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+
+	return i
+}
+
+func (c *ChunkRenderOpts) GetFloat(key string, def float64) float64 {
+	v, ok := c.data[key]
+	if !ok {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+
+	return f
+}
+
+// GetPrefixed returns every option starting with prefix, keyed by the rest of the name
+func (c *ChunkRenderOpts) GetPrefixed(prefix string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range c.data {
+		if name, ok := strings.CutPrefix(key, prefix); ok {
+			result[name] = value
+		}
+	}
+	return result
+}
+
+// GetColor parses a hex color such as #ff00ff, the value none returns nil
+func (c *ChunkRenderOpts) GetColor(key string, def color.Color) color.Color {
+	v, ok := c.data[key]
+	if !ok {
+		return def
+	}
+
+	if v == "none" {
+		return nil
+	}
+
+	clr, err := parseHexColor(v)
+	if err != nil {
+		return def
+	}
+	return clr
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/b1naryth1ef/carto"
 	"github.com/b1naryth1ef/carto/build"
+	"github.com/b1naryth1ef/carto/serve"
 	"github.com/urfave/cli/v2"
 )
 
@@ -31,6 +35,161 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:   "serve",
+				Usage:  "serve a built output directory over http",
+				Action: commandServe,
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:  "config",
+						Usage: "path to the configuration file, only read for --output or --watch",
+						Value: "config.hcl",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "name of the output to serve, defaults to the first output in the config",
+					},
+					&cli.PathFlag{
+						Name:  "path",
+						Usage: "output directory to serve instead of an output from the config",
+					},
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "address to listen on",
+						Value: ":8080",
+					},
+					&cli.StringFlag{
+						Name:  "base-path",
+						Usage: "path prefix to serve the map under, e.g. /map",
+					},
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "re-render regions as they are saved and reload open browsers",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "how often to check for changed regions when watching",
+						Value: 5 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "debounce",
+						Usage: "how long a region must be unmodified before it is rendered",
+						Value: 10 * time.Second,
+					},
+				},
+			},
+			{
+				Name:   "watch",
+				Usage:  "build once then re-render regions as the server saves them",
+				Action: commandWatch,
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:  "config",
+						Usage: "path to the configuration file",
+						Value: "config.hcl",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "how often to check for changed regions",
+						Value: 5 * time.Second,
+					},
+					&cli.DurationFlag{
+						Name:  "debounce",
+						Usage: "how long a region must be unmodified before it is rendered",
+						Value: 10 * time.Second,
+					},
+				},
+			},
+			{
+				Name:   "dimensions",
+				Usage:  "list the dimensions found in a world save",
+				Action: commandDimensions,
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:     "world",
+						Usage:    "path to the root of the world save",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:  "palette",
+				Usage: "inspect the block colors used by a map",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "dump",
+						Usage:  "write every resolved block state and biome color to a file",
+						Action: commandPaletteDump,
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "config",
+								Usage: "path to the configuration file",
+								Value: "config.hcl",
+							},
+							&cli.StringFlag{
+								Name:  "map",
+								Usage: "name of the map to dump, defaults to the first map in the config",
+							},
+							&cli.PathFlag{
+								Name:  "output",
+								Usage: "path to write the palette to",
+								Value: "palette.json",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "json or csv, csv files list the biome colors below the blocks",
+								Value: "json",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "export map data for use in other tools",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "heightmap",
+						Usage:  "write the surface y level of every column as a 16 bit png or raw int16 grid",
+						Action: commandExportHeightmap,
+						Flags: []cli.Flag{
+							&cli.PathFlag{
+								Name:  "config",
+								Usage: "path to the configuration file",
+								Value: "config.hcl",
+							},
+							&cli.StringFlag{
+								Name:  "map",
+								Usage: "name of the map to export, defaults to the first map in the config",
+							},
+							&cli.PathFlag{
+								Name:  "output",
+								Usage: "path to write the heightmap to, the json sidecar is written next to it",
+								Value: "heightmap.png",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "png for 16 bit grayscale or raw for little endian int16 samples",
+								Value: "png",
+							},
+							&cli.StringFlag{
+								Name:  "heightmap",
+								Usage: "surface or ocean-floor",
+								Value: "surface",
+							},
+							&cli.StringFlag{
+								Name:  "bounds",
+								Usage: "area to export in block coordinates as min_x,min_z,max_x,max_z, defaults to the maps bounds or every generated chunk",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:   "renderers",
+				Usage:  "list the available layer renderers and their options",
+				Action: commandRenderers,
+			},
 		},
 	}
 
@@ -51,3 +210,192 @@ func commandBuild(ctx *cli.Context) error {
 		ForceClean: ctx.Bool("clean"),
 	})
 }
+
+func commandWatch(ctx *cli.Context) error {
+	config, err := carto.LoadConfig(ctx.Path("config"))
+	if err != nil {
+		return err
+	}
+
+	return build.Watch(config, build.WatchOpts{
+		Interval: ctx.Duration("interval"),
+		Debounce: ctx.Duration("debounce"),
+	})
+}
+
+func commandPaletteDump(ctx *cli.Context) error {
+	config, err := carto.LoadConfig(ctx.Path("config"))
+	if err != nil {
+		return err
+	}
+
+	format := ctx.String("format")
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unsupported palette format '%s'", format)
+	}
+
+	mapCfg, err := findMap(config, ctx.String("map"))
+	if err != nil {
+		return err
+	}
+
+	var outputPath string
+	for _, output := range config.Outputs {
+		if output.Name == mapCfg.Output {
+			outputPath = output.Path
+		}
+	}
+
+	assetLoader, err := build.LoadMapAssets(mapCfg, outputPath)
+	if err != nil {
+		return err
+	}
+	defer assetLoader.Close()
+
+	palette, err := carto.NewPalette(assetLoader)
+	if err != nil {
+		return err
+	}
+
+	file := palette.Dump()
+	err = carto.WritePaletteFile(ctx.Path("output"), format, file)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[palette] wrote %d block states and %d biomes to %s", len(file.Blocks), len(file.Biomes), ctx.Path("output"))
+	if missing := palette.GetMissingBlockStates(); len(missing) > 0 {
+		log.Printf("[palette] %d blocks could not be resolved: %s", len(missing), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// findMap returns the named map, or the first map if name is empty
+func findMap(config *carto.Config, name string) (*carto.MapConfigBlock, error) {
+	for _, m := range config.Maps {
+		if name == "" || m.Name == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no map named '%s' in config", name)
+}
+
+func commandExportHeightmap(ctx *cli.Context) error {
+	config, err := carto.LoadConfig(ctx.Path("config"))
+	if err != nil {
+		return err
+	}
+
+	format := ctx.String("format")
+	err = carto.CheckHeightmapOutput(ctx.Path("output"), format)
+	if err != nil {
+		return err
+	}
+
+	mapCfg, err := findMap(config, ctx.String("map"))
+	if err != nil {
+		return err
+	}
+
+	regionPath, err := mapCfg.RegionPath()
+	if err != nil {
+		return err
+	}
+
+	area, err := mapCfg.Area()
+	if err != nil {
+		return err
+	}
+
+	// the grid covers the maps area unless bounds are given explicitly, columns
+	// outside of the area are left without data either way
+	opts := carto.HeightmapExportOpts{
+		Heightmap: ctx.String("heightmap"),
+		Area:      area,
+	}
+	if value := ctx.String("bounds"); value != "" {
+		var bounds carto.Bounds
+		if _, err := fmt.Sscanf(value, "%d,%d,%d,%d", &bounds.MinX, &bounds.MinZ, &bounds.MaxX, &bounds.MaxZ); err != nil {
+			return fmt.Errorf("invalid bounds '%s', expected min_x,min_z,max_x,max_z", value)
+		}
+		opts.Bounds = &bounds
+	}
+
+	heightmap, err := carto.ExportHeightmap(regionPath, opts)
+	if err != nil {
+		return err
+	}
+
+	err = carto.WriteHeightmap(ctx.Path("output"), format, heightmap)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[export] wrote %dx%d heightmap from (%d, %d) to %s", heightmap.Width, heightmap.Height, heightmap.OriginX, heightmap.OriginZ, ctx.Path("output"))
+	return nil
+}
+
+func commandRenderers(ctx *cli.Context) error {
+	for _, info := range carto.Renderers() {
+		fmt.Printf("%s - %s\n", info.Name, info.Description)
+		for _, opt := range info.Options {
+			fmt.Printf("  %s (default: %s) - %s\n", opt.Name, opt.Default, opt.Description)
+		}
+	}
+	return nil
+}
+
+func commandDimensions(ctx *cli.Context) error {
+	dimensions, err := carto.DiscoverDimensions(ctx.Path("world"))
+	if err != nil {
+		return err
+	}
+
+	for _, dim := range dimensions {
+		fmt.Printf("%s - %s\n", dim.ID, dim.RegionPath)
+	}
+	return nil
+}
+
+func commandServe(ctx *cli.Context) error {
+	path := ctx.Path("path")
+	if path != "" && ctx.String("output") != "" {
+		return fmt.Errorf("--path and --output cannot be used together")
+	}
+
+	// a directory given with --path is served as is, the config is only needed
+	// to find an output or to render while watching
+	var config *carto.Config
+	if path == "" || ctx.Bool("watch") {
+		var err error
+		config, err = carto.LoadConfig(ctx.Path("config"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if path == "" {
+		var output *carto.OutputConfigBlock
+		for _, o := range config.Outputs {
+			if ctx.String("output") == "" || o.Name == ctx.String("output") {
+				output = o
+				break
+			}
+		}
+
+		if output == nil {
+			return fmt.Errorf("no output named '%s' in config", ctx.String("output"))
+		}
+		path = output.Path
+	}
+
+	return serve.Serve(serve.ServeOpts{
+		Listen:        ctx.String("listen"),
+		Path:          path,
+		BasePath:      ctx.String("base-path"),
+		Watch:         ctx.Bool("watch"),
+		WatchInterval: ctx.Duration("interval"),
+		WatchDebounce: ctx.Duration("debounce"),
+		Config:        config,
+	})
+}
@@ -1,6 +1,11 @@
 package carto
 
 import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/zclconf/go-cty/cty"
@@ -21,10 +26,11 @@ type OutputConfigBlock struct {
 }
 
 type LayerConfigBlock struct {
-	Name    string            `hcl:"name,label"`
-	Render  string            `hcl:"render"`
-	Opacity float64           `hcl:"opacity,optional"`
-	Options map[string]string `hcl:"options,optional"`
+	Name       string            `hcl:"name,label"`
+	Render     string            `hcl:"render"`
+	Opacity    float64           `hcl:"opacity,optional"`
+	ZoomLevels int               `hcl:"zoom_levels,optional"`
+	Options    map[string]string `hcl:"options,optional"`
 }
 
 type MapConfigBlock struct {
@@ -33,6 +39,34 @@ type MapConfigBlock struct {
 	Path    string   `hcl:"path"`
 	Layers  []string `hcl:"layers"`
 	Version string   `hcl:"version,optional"`
+
+	// Dimension makes Path the root of the world save rather than a region directory
+	Dimension string `hcl:"dimension,optional"`
+
+	// ResourcePacks are zip packs, unpacked directories or mod JARs stacked on
+	// top of the client JAR, earlier entries take priority over later ones
+	ResourcePacks []string `hcl:"resource_packs,optional"`
+
+	// Markers is the list of marker types published for this map, defaults to DefaultMarkerTypes
+	Markers *[]string `hcl:"markers,optional"`
+
+	Palette *PaletteConfigBlock `hcl:"palette,block"`
+
+	// Bounds limits rendering to an area in block coordinates, Include and
+	// Exclude are lists of polygons given as [x, z] points
+	Bounds  *Bounds   `hcl:"bounds,optional"`
+	Include [][][]int `hcl:"include,optional"`
+	Exclude [][][]int `hcl:"exclude,optional"`
+}
+
+type PaletteConfigBlock struct {
+	// File is a palette written by `carto palette dump`, when set the client
+	// JAR is not downloaded and block colors come from the file
+	File string `hcl:"file,optional"`
+
+	// Overrides maps block names or blockstate patterns, such as
+	// minecraft:oak_log[axis=y] or minecraft:*_leaves, to hex colors
+	Overrides map[string]string `hcl:"overrides,optional"`
 }
 
 func newHCLEvalContext() *hcl.EvalContext {
@@ -49,5 +83,99 @@ func LoadConfig(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+func (c *Config) validate() error {
+	for _, layer := range c.Layers {
+		if _, ok := GetRenderer(layer.Render); !ok {
+			return fmt.Errorf("layer %s: unsupported renderer '%s'", layer.Name, layer.Render)
+		}
+	}
+
+	for _, m := range c.Maps {
+		for _, markerType := range m.MarkerTypes() {
+			if !slices.Contains(MarkerTypes, markerType) {
+				return fmt.Errorf("map %s: unknown marker type '%s' (available: %s)", m.Name, markerType, strings.Join(MarkerTypes, ", "))
+			}
+		}
+
+		if _, err := m.ColorOverrides(); err != nil {
+			return err
+		}
+
+		if _, err := m.Area(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarkerTypes returns the marker types published for a map
+func (m *MapConfigBlock) MarkerTypes() []string {
+	if m.Markers == nil {
+		return DefaultMarkerTypes
+	}
+	return *m.Markers
+}
+
+// Area returns the part of the world rendered for a map, nil when it is unbounded
+func (m *MapConfigBlock) Area() (*RenderArea, error) {
+	if m.Bounds == nil && len(m.Include) == 0 && len(m.Exclude) == 0 {
+		return nil, nil
+	}
+
+	area := &RenderArea{Bounds: m.Bounds}
+	if m.Bounds != nil && (m.Bounds.MinX > m.Bounds.MaxX || m.Bounds.MinZ > m.Bounds.MaxZ) {
+		return nil, fmt.Errorf("map %s: bounds minimum must not be greater than the maximum", m.Name)
+	}
+
+	for _, points := range m.Include {
+		polygon, err := newPolygon(points)
+		if err != nil {
+			return nil, fmt.Errorf("map %s: include: %v", m.Name, err)
+		}
+		area.Include = append(area.Include, polygon)
+	}
+
+	for _, points := range m.Exclude {
+		polygon, err := newPolygon(points)
+		if err != nil {
+			return nil, fmt.Errorf("map %s: exclude: %v", m.Name, err)
+		}
+		area.Exclude = append(area.Exclude, polygon)
+	}
+
+	if extent := area.Extent(); extent != nil && (extent.MinX > extent.MaxX || extent.MinZ > extent.MaxZ) {
+		return nil, fmt.Errorf("map %s: include polygons are outside of the bounds", m.Name)
+	}
+	return area, nil
+}
+
+// ColorOverrides parses the palette overrides of a map
+func (m *MapConfigBlock) ColorOverrides() ([]ColorOverride, error) {
+	if m.Palette == nil {
+		return nil, nil
+	}
+
+	patterns := make([]string, 0, len(m.Palette.Overrides))
+	for pattern := range m.Palette.Overrides {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	overrides := make([]ColorOverride, 0, len(patterns))
+	for _, pattern := range patterns {
+		override, err := ParseColorOverride(pattern, m.Palette.Overrides[pattern])
+		if err != nil {
+			return nil, fmt.Errorf("map %s: %v", m.Name, err)
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
@@ -0,0 +1,181 @@
+package carto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	DimensionOverworld = "minecraft:overworld"
+	DimensionNether    = "minecraft:the_nether"
+	DimensionEnd       = "minecraft:the_end"
+)
+
+// dimensionAliases maps the short names accepted in config to dimension ids
+var dimensionAliases = map[string]string{
+	"overworld":  DimensionOverworld,
+	"nether":     DimensionNether,
+	"the_nether": DimensionNether,
+	"end":        DimensionEnd,
+	"the_end":    DimensionEnd,
+}
+
+// dimensionRenderDefaults holds layer options applied to a dimension unless a
+// layer sets them itself
+var dimensionRenderDefaults = map[string]map[string]string{
+	DimensionNether: {
+		"strip-ceiling": "true",
+		"sky-light":     "false",
+	},
+	DimensionEnd: {
+		"sky-light": "false",
+	},
+}
+
+// Dimension is a single dimension within a world save
+type Dimension struct {
+	ID         string
+	RegionPath string
+}
+
+// ResolveDimensionID converts a dimension name from config into a namespaced id
+func ResolveDimensionID(name string) string {
+	if id, ok := dimensionAliases[name]; ok {
+		return id
+	}
+
+	if !strings.Contains(name, ":") {
+		return "minecraft:" + name
+	}
+	return name
+}
+
+// dimensionRegionPath returns the region directory for a dimension inside a world
+func dimensionRegionPath(worldPath, id string) string {
+	switch id {
+	case DimensionOverworld:
+		return filepath.Join(worldPath, "region")
+	case DimensionNether:
+		return filepath.Join(worldPath, "DIM-1", "region")
+	case DimensionEnd:
+		return filepath.Join(worldPath, "DIM1", "region")
+	}
+
+	namespace, name, _ := strings.Cut(id, ":")
+	return filepath.Join(worldPath, "dimensions", namespace, name, "region")
+}
+
+// DiscoverDimensions returns every dimension with a region directory in a world,
+// including custom datapack dimensions under dimensions/<namespace>/<name>
+func DiscoverDimensions(worldPath string) ([]Dimension, error) {
+	result := []Dimension{}
+	for _, id := range []string{DimensionOverworld, DimensionNether, DimensionEnd} {
+		regionPath := dimensionRegionPath(worldPath, id)
+		if isDirectory(regionPath) {
+			result = append(result, Dimension{ID: id, RegionPath: regionPath})
+		}
+	}
+
+	namespaces, err := os.ReadDir(filepath.Join(worldPath, "dimensions"))
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	custom := []Dimension{}
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() {
+			continue
+		}
+
+		names, err := os.ReadDir(filepath.Join(worldPath, "dimensions", namespace.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			id := namespace.Name() + ":" + name.Name()
+
+			// vanilla dimensions never live here, but guard against duplicates anyway
+			if id == DimensionOverworld || id == DimensionNether || id == DimensionEnd {
+				continue
+			}
+
+			regionPath := dimensionRegionPath(worldPath, id)
+			if isDirectory(regionPath) {
+				custom = append(custom, Dimension{ID: id, RegionPath: regionPath})
+			}
+		}
+	}
+
+	sort.Slice(custom, func(i, j int) bool {
+		return custom[i].ID < custom[j].ID
+	})
+
+	return append(result, custom...), nil
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// DimensionID returns the dimension id for a map, maps without a dimension
+// point directly at a region directory and are treated as the overworld
+func (m *MapConfigBlock) DimensionID() string {
+	if m.Dimension == "" {
+		return DimensionOverworld
+	}
+	return ResolveDimensionID(m.Dimension)
+}
+
+// WorldPath returns the root of the world save for a map
+func (m *MapConfigBlock) WorldPath() string {
+	if m.Dimension == "" {
+		return filepath.Join(m.Path, "..")
+	}
+	return m.Path
+}
+
+// RegionPath returns the directory containing the region files for a map
+func (m *MapConfigBlock) RegionPath() (string, error) {
+	if m.Dimension == "" {
+		return m.Path, nil
+	}
+
+	id := m.DimensionID()
+	regionPath := dimensionRegionPath(m.Path, id)
+	if isDirectory(regionPath) {
+		return regionPath, nil
+	}
+
+	dimensions, err := DiscoverDimensions(m.Path)
+	if err != nil {
+		return "", err
+	}
+
+	available := []string{}
+	for _, dim := range dimensions {
+		available = append(available, dim.ID)
+	}
+
+	return "", fmt.Errorf("map %s: dimension %s not found in %s (available: %s)", m.Name, id, m.Path, strings.Join(available, ", "))
+}
+
+// LayerOptions returns the options for a layer when rendered in this map, with
+// any defaults for the maps dimension applied
+func (m *MapConfigBlock) LayerOptions(layer *LayerConfigBlock) map[string]string {
+	result := make(map[string]string)
+	for k, v := range dimensionRenderDefaults[m.DimensionID()] {
+		result[k] = v
+	}
+
+	for k, v := range layer.Options {
+		result[k] = v
+	}
+	return result
+}
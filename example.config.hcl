@@ -6,22 +6,107 @@ output "web" {
 }
 
 layer "normal" {
-  render = "pixel"
+  render      = "pixel"
+  zoom_levels = 4
+  options = {
+    biome-blend = "2"
+
+    # relief lights slopes from the light direction, flat only darkens steps
+    shading          = "relief"
+    light-azimuth    = "315"
+    light-altitude   = "45"
+    shading-strength = "0.5"
+  }
 }
 
 layer "biome" {
   render  = "biome"
   opacity = 0.5
+  options = {
+    "color.minecraft:plains" = "#8db360"
+  }
 }
 
+# night and day darken columns by their light level, spawnable highlights
+# surfaces dark enough for hostile mobs to spawn
 layer "light" {
   render = "light"
+  options = {
+    mode = "night"
+  }
+}
+
+# surface y levels as hypsometric tints with a contour line every 16 blocks,
+# ramp takes comma separated y:#color stops
+layer "height" {
+  render = "height"
+  options = {
+    contour-interval = "16"
+  }
+}
+
+# cave floors between y=0 and y=40, define more layers for other slices
+layer "caves" {
+  render = "pixel"
+  options = {
+    max-y = "40"
+    min-y = "0"
+    caves = "true"
+  }
+}
+
+layer "isometric" {
+  render = "isometric"
+  options = {
+    block-size = "8"
+  }
 }
 
 map "overworld" {
   output = "web"
   path   = "/home/andrei/mc/world/region"
   layers = ["normal", "biome", "light"]
+
+  # only render chunks within these block coordinates, include and exclude
+  # take lists of polygons as [x, z] points
+  # bounds  = { min_x = -4096, min_z = -4096, max_x = 4096, max_z = 4096 }
+  # exclude = [[[1000, 1000], [1200, 1000], [1200, 1200], [1000, 1200]]]
+
+  # resource packs, unpacked pack directories and mod jars layered over the
+  # client jar, the first entry has the highest priority
+  # resource_packs = ["/home/andrei/mc/resourcepacks/faithful.zip", "/home/andrei/mc/mods/create.jar"]
+
+  palette {
+    # a file written by `carto palette dump`, used instead of the client jar
+    # file = "/home/andrei/mc/palette.json"
+
+    # the most specific pattern matching a block wins
+    overrides = {
+      "minecraft:water"                   = "#3355cc"
+      "minecraft:*_leaves"                = "#2f6b1f"
+      "minecraft:grass_block[snowy=true]" = "#f0f0f0"
+    }
+  }
+}
+
+# with a dimension set the path points at the world root, the nether strips its
+# bedrock ceiling by default
+map "nether" {
+  output    = "web"
+  path      = "/home/andrei/mc/world"
+  dimension = "nether"
+  layers    = ["normal", "biome"]
+
+  # marker types shown on the map, players are opt-in as their positions are
+  # usually private. available: player, spawn, sign, banner, lodestone
+  markers = ["player", "sign", "banner", "lodestone"]
+}
+
+# isometric tiles do not line up with top-down tiles so they get their own map
+map "overworld-3d" {
+  output = "web"
+  path   = "/home/andrei/mc/world/region"
+  layers = ["isometric"]
 }
 
 map "hermitcraft9" {
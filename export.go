@@ -0,0 +1,404 @@
+package carto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Tnze/go-mc/save"
+	"github.com/Tnze/go-mc/save/region"
+)
+
+// HeightmapNoData is the height of columns without any blocks or outside of
+// the rendered chunks
+const HeightmapNoData = math.MinInt16
+
+// maxHeightmapSamples limits the size of an exported grid, 16384 blocks square
+// already takes 512MB to hold
+const maxHeightmapSamples = 16384 * 16384
+
+type HeightmapExportOpts struct {
+	// Heightmap is surface or ocean-floor, see the height renderer
+	Heightmap string
+
+	// Bounds is the extent of the exported grid, defaults to the extent of the
+	// area or every generated chunk
+	Bounds *Bounds
+
+	// Area masks the exported columns, columns outside of it have no data
+	Area *RenderArea
+
+	Concurrency int
+}
+
+// Heightmap is a grid of surface y levels, one per block starting at the north
+// west corner in row order
+type Heightmap struct {
+	Heightmap string
+	OriginX   int
+	OriginZ   int
+	Width     int
+	Height    int
+	Heights   []int16
+}
+
+// heightmapSidecar describes an exported heightmap for external tools, the y
+// level of a sample is its value plus offset
+type heightmapSidecar struct {
+	Format    string `json:"format"`
+	Heightmap string `json:"heightmap"`
+	OriginX   int    `json:"origin_x"`
+	OriginZ   int    `json:"origin_z"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	BlockSize int    `json:"block_size"`
+	Offset    int    `json:"offset"`
+	NoData    int    `json:"nodata"`
+	ByteOrder string `json:"byte_order,omitempty"`
+}
+
+// isChunkComplete reports whether a chunk has finished generating
+func isChunkComplete(chunk *save.Chunk) bool {
+	return chunk.Status == "minecraft:full" ||
+		chunk.Status == "minecraft:spawn" ||
+		chunk.Status == "minecraft:postprocessed" ||
+		chunk.Status == "minecraft:fullchunk"
+}
+
+// exportRegionFiles returns the region files within src which are within the
+// area and overlap bounds
+func exportRegionFiles(src string, area *RenderArea, bounds *Bounds) ([]regionFile, error) {
+	files, err := listRegionFiles(src, area)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []regionFile{}
+	for _, file := range files {
+		if file.crdErr != nil || filepath.Ext(file.name) != ".mca" {
+			continue
+		}
+
+		crd := file.crd
+		if bounds != nil && !bounds.overlaps(crd.X*512, crd.Z*512, crd.X*512+511, crd.Z*512+511) {
+			continue
+		}
+		result = append(result, file)
+	}
+	return result, nil
+}
+
+// generatedBounds returns the area covered by every chunk in the region files
+func generatedBounds(src string, files []regionFile) (*Bounds, error) {
+	var bounds *Bounds
+	for _, file := range files {
+		path := filepath.Join(src, file.name)
+		reg, err := region.Open(path)
+		if errors.Is(err, io.EOF) {
+			// the game leaves empty region files behind
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read region file %s: %v", path, err)
+		}
+
+		for x := 0; x < 32; x++ {
+			for z := 0; z < 32; z++ {
+				if !reg.ExistSector(x, z) {
+					continue
+				}
+
+				blockX, blockZ := (file.crd.X*32+x)*16, (file.crd.Z*32+z)*16
+				if bounds == nil {
+					bounds = &Bounds{MinX: blockX, MinZ: blockZ, MaxX: blockX + 15, MaxZ: blockZ + 15}
+					continue
+				}
+				bounds.MinX = min(bounds.MinX, blockX)
+				bounds.MinZ = min(bounds.MinZ, blockZ)
+				bounds.MaxX = max(bounds.MaxX, blockX+15)
+				bounds.MaxZ = max(bounds.MaxZ, blockZ+15)
+			}
+		}
+		reg.Close()
+	}
+
+	if bounds == nil {
+		return nil, fmt.Errorf("no chunks found in %s", src)
+	}
+	return bounds, nil
+}
+
+// ExportHeightmap reads the surface y level of every column within the bounds
+// and area from the region files in src, regions which fail to read are
+// reported in the returned error
+func ExportHeightmap(src string, opts HeightmapExportOpts) (*Heightmap, error) {
+	name := opts.Heightmap
+	if name == "" {
+		name = "surface"
+	}
+
+	heightmap, ok := heightmapNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown heightmap '%s' (available: surface, ocean-floor)", name)
+	}
+
+	bounds := opts.Bounds
+	if bounds == nil {
+		bounds = opts.Area.Extent()
+	}
+
+	files, err := exportRegionFiles(src, opts.Area, bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	if bounds == nil {
+		bounds, err = generatedBounds(src, files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &Heightmap{
+		Heightmap: name,
+		OriginX:   bounds.MinX,
+		OriginZ:   bounds.MinZ,
+		Width:     bounds.MaxX - bounds.MinX + 1,
+		Height:    bounds.MaxZ - bounds.MinZ + 1,
+	}
+	if result.Width <= 0 || result.Height <= 0 {
+		return nil, fmt.Errorf("empty bounds (%d, %d) to (%d, %d)", bounds.MinX, bounds.MinZ, bounds.MaxX, bounds.MaxZ)
+	}
+	if result.Width > maxHeightmapSamples/result.Height {
+		return nil, fmt.Errorf("bounds (%d, %d) to (%d, %d) cover more than %d blocks, export smaller bounds", bounds.MinX, bounds.MinZ, bounds.MaxX, bounds.MaxZ, maxHeightmapSamples)
+	}
+
+	result.Heights = make([]int16, result.Width*result.Height)
+	for idx := range result.Heights {
+		result.Heights[idx] = HeightmapNoData
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	guard := make(chan struct{}, concurrency)
+
+	// every chunk covers its own columns so regions can write to the grid
+	// concurrently
+	var wg sync.WaitGroup
+	var failed atomic.Uint32
+	for _, file := range files {
+		guard <- struct{}{}
+		wg.Add(1)
+		go func(file regionFile) {
+			defer wg.Done()
+			defer func() {
+				<-guard
+			}()
+
+			err := result.readRegion(filepath.Join(src, file.name), file.crd, heightmap, bounds, opts.Area)
+			if err != nil {
+				log.Printf("[export] failed to read region file %s: %v", filepath.Join(src, file.name), err)
+				failed.Add(1)
+			}
+		}(file)
+	}
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		return nil, fmt.Errorf("failed to read %d of %d region files", n, len(files))
+	}
+	return result, nil
+}
+
+// readRegion copies the heights of a regions chunks within bounds and the area
+// into the grid
+func (h *Heightmap) readRegion(path string, crd coord, heightmap string, bounds *Bounds, area *RenderArea) error {
+	reg, err := region.Open(path)
+	if errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reg.Close()
+
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			chunkX, chunkZ := (crd.X*32+x)*16, (crd.Z*32+z)*16
+			if !bounds.overlaps(chunkX, chunkZ, chunkX+15, chunkZ+15) || !area.containsChunk(crd.X*32+x, crd.Z*32+z) {
+				continue
+			}
+
+			sector, err := reg.ReadSector(x, z)
+			if errors.Is(err, region.ErrNoSector) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			var chunk save.Chunk
+			err = chunk.Load(sector)
+			if err != nil {
+				return err
+			}
+
+			if !isChunkComplete(&chunk) || len(chunk.Sections) == 0 {
+				continue
+			}
+
+			heights := chunkHeightmap(&chunk, heightmap)
+			minY := chunkMinY(&chunk)
+			for columnX := 0; columnX < 16; columnX++ {
+				for columnZ := 0; columnZ < 16; columnZ++ {
+					blockX, blockZ := chunkX+columnX, chunkZ+columnZ
+					if blockX < bounds.MinX || blockX > bounds.MaxX || blockZ < bounds.MinZ || blockZ > bounds.MaxZ {
+						continue
+					}
+					if !area.containsBlock(blockX, blockZ) {
+						continue
+					}
+
+					// heightmaps hold the first free block above the surface
+					height := heights.Get((columnZ * 16) + columnX)
+					if height == 0 {
+						continue
+					}
+					h.Heights[(blockZ-h.OriginZ)*h.Width+(blockX-h.OriginX)] = int16(height + minY - 1)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// heightmapSidecarPath returns the path of the json sidecar written next to an
+// exported heightmap
+func heightmapSidecarPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+}
+
+// CheckHeightmapOutput reports whether a heightmap can be written to path in
+// format, outputs which would be overwritten by their sidecar are rejected
+func CheckHeightmapOutput(path, format string) error {
+	if format != "png" && format != "raw" {
+		return fmt.Errorf("unsupported heightmap format '%s'", format)
+	}
+
+	// compared without case as most desktop filesystems ignore it
+	if strings.EqualFold(filepath.Clean(heightmapSidecarPath(path)), filepath.Clean(path)) {
+		return fmt.Errorf("heightmap output %s is also the path of its json sidecar, use a different extension", path)
+	}
+	return nil
+}
+
+// WriteHeightmap writes a heightmap as a 16 bit grayscale png or raw little
+// endian int16 samples, along with a json sidecar describing it
+func WriteHeightmap(path, format string, h *Heightmap) error {
+	err := CheckHeightmapOutput(path, format)
+	if err != nil {
+		return err
+	}
+
+	sidecar := heightmapSidecar{
+		Format:    format,
+		Heightmap: h.Heightmap,
+		OriginX:   h.OriginX,
+		OriginZ:   h.OriginZ,
+		Width:     h.Width,
+		Height:    h.Height,
+		BlockSize: 1,
+	}
+
+	switch format {
+	case "png":
+		sidecar.Offset, sidecar.NoData = h.pngOffset(), 0
+		err = h.writePNG(path, sidecar.Offset)
+	case "raw":
+		sidecar.NoData, sidecar.ByteOrder = HeightmapNoData, "little"
+		err = h.writeRaw(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(heightmapSidecarPath(path), data, 0644)
+}
+
+// pngOffset returns the offset which maps the lowest height to one, png
+// samples are unsigned and zero is kept for columns without data
+func (h *Heightmap) pngOffset() int {
+	lowest := math.MaxInt16
+	for _, height := range h.Heights {
+		if height != HeightmapNoData {
+			lowest = min(lowest, int(height))
+		}
+	}
+
+	if lowest == math.MaxInt16 {
+		return 0
+	}
+	return lowest - 1
+}
+
+func (h *Heightmap) writePNG(path string, offset int) error {
+	img := image.NewGray16(image.Rect(0, 0, h.Width, h.Height))
+	for idx, height := range h.Heights {
+		if height == HeightmapNoData {
+			continue
+		}
+		img.SetGray16(idx%h.Width, idx/h.Width, color.Gray16{Y: uint16(int(height) - offset)})
+	}
+
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	return png.Encode(fd, img)
+}
+
+// writeRaw writes the samples a row at a time, encoding the whole grid at once
+// would double the memory an export takes
+func (h *Heightmap) writeRaw(path string) error {
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	w := bufio.NewWriter(fd)
+	row := make([]byte, h.Width*2)
+	for z := 0; z < h.Height; z++ {
+		for x, height := range h.Heights[z*h.Width : (z+1)*h.Width] {
+			binary.LittleEndian.PutUint16(row[x*2:], uint16(height))
+		}
+
+		_, err = w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,294 @@
+package carto
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Tnze/go-mc/level"
+	"github.com/Tnze/go-mc/save"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// heightmapNames are the chunk heightmaps the height renderer can color by
+var heightmapNames = map[string]string{
+	"surface":     "MOTION_BLOCKING",
+	"ocean-floor": "OCEAN_FLOOR",
+}
+
+// defaultHeightRamp is a hypsometric tint with blues below sea level
+const defaultHeightRamp = "-64:#0b1d51,40:#1f5fa8,62:#6fa8dc,63:#4f8f3a,90:#9cc26b,130:#e8d9a0,170:#b08a5a,220:#7d6b5d,260:#ffffff"
+
+// heightStop is a color at a y level within a heightRamp
+type heightStop struct {
+	y     int
+	color color.NRGBA
+}
+
+// heightRamp maps y levels to colors, interpolating between its stops
+type heightRamp []heightStop
+
+// parseHeightRamp parses a comma separated list of y:#color stops
+func parseHeightRamp(value string) (heightRamp, error) {
+	var ramp heightRamp
+	for _, part := range strings.Split(value, ",") {
+		y, hex, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid ramp stop '%s', expected y:#color", part)
+		}
+
+		level, err := strconv.Atoi(strings.TrimSpace(y))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ramp stop '%s': %v", part, err)
+		}
+
+		clr, err := parseHexColor(strings.TrimSpace(hex))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ramp stop '%s': %v", part, err)
+		}
+		ramp = append(ramp, heightStop{y: level, color: clr})
+	}
+
+	sort.SliceStable(ramp, func(i, j int) bool {
+		return ramp[i].y < ramp[j].y
+	})
+	return ramp, nil
+}
+
+// colorAt returns the color of a y level, levels outside the ramp use the
+// color of the closest stop
+func (r heightRamp) colorAt(y int) color.Color {
+	idx := sort.Search(len(r), func(i int) bool {
+		return r[i].y >= y
+	})
+	if idx == 0 {
+		return r[0].color
+	}
+	if idx == len(r) {
+		return r[len(r)-1].color
+	}
+
+	low, high := r[idx-1], r[idx]
+	t := float64(y-low.y) / float64(high.y-low.y)
+
+	// blending in lab keeps the gradient between stops perceptually even
+	from := colorful.Color{R: float64(low.color.R) / 255, G: float64(low.color.G) / 255, B: float64(low.color.B) / 255}
+	to := colorful.Color{R: float64(high.color.R) / 255, G: float64(high.color.G) / 255, B: float64(high.color.B) / 255}
+	red, green, blue := from.BlendLab(to, t).Clamped().RGB255()
+	return color.NRGBA{
+		R: red,
+		G: green,
+		B: blue,
+		A: uint8(math.Round(float64(low.color.A) + (float64(high.color.A)-float64(low.color.A))*t)),
+	}
+}
+
+// HeightRenderer colors each column by the y level of its surface, optionally
+// drawing contour lines
+type HeightRenderer struct {
+	sync.Mutex
+
+	heightmap string
+	ramp      heightRamp
+
+	// contourInterval is the number of blocks between contour lines, zero
+	// when they are not drawn
+	contourInterval int
+	contourColor    color.Color
+
+	// pending holds the chunks rendered since their region was last stored
+	pending map[coord]*heightChunk
+}
+
+// heightChunk is what contour lines need from a rendered chunk until its
+// region is finished
+type heightChunk struct {
+	// minY is the bottom of the world the heights are relative to
+	minY    int
+	heights *level.BitStorage
+}
+
+func init() {
+	RegisterRenderer(RendererInfo{
+		Name:        "height",
+		Description: "colors each column by the y level of its surface",
+		Options: []RendererOption{
+			{Name: "heightmap", Default: "surface", Description: "surface includes water and leaves, ocean-floor colors the ground below them"},
+			{Name: "ramp", Default: defaultHeightRamp, Description: "comma separated y:#color stops, colors between them are interpolated"},
+			{Name: "contour-interval", Default: "0", Description: "draw a contour line every this many blocks, 0 to disable"},
+			{Name: "contour-color", Default: "#00000080", Description: "color of contour lines, #rrggbbaa for transparency"},
+		},
+		Factory: func(opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error) {
+			renderer, err := NewHeightRenderer(opts)
+			if err != nil {
+				return nil, err
+			}
+			return renderer, nil
+		},
+	})
+}
+
+func NewHeightRenderer(opts *ChunkRenderOpts) (*HeightRenderer, error) {
+	name := opts.GetString("heightmap", "surface")
+	heightmap, ok := heightmapNames[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown heightmap '%s' (available: surface, ocean-floor)", name)
+	}
+
+	ramp, err := parseHeightRamp(opts.GetString("ramp", defaultHeightRamp))
+	if err != nil {
+		return nil, err
+	}
+
+	// a contour color of none also disables them
+	contourInterval := max(opts.GetInt("contour-interval", 0), 0)
+	contourColor := opts.GetColor("contour-color", color.NRGBA{A: 0x80})
+	if contourColor == nil {
+		contourInterval = 0
+	}
+
+	return &HeightRenderer{
+		heightmap:       heightmap,
+		ramp:            ramp,
+		contourInterval: contourInterval,
+		contourColor:    contourColor,
+		pending:         make(map[coord]*heightChunk),
+	}, nil
+}
+
+func (c *HeightRenderer) ImageSize() (int, int) {
+	return 16, 16
+}
+
+func (c *HeightRenderer) LegendFile() string {
+	return "heights.json"
+}
+
+// Finalize writes a legend with the color of each ramp stop
+func (c *HeightRenderer) Finalize(path string) error {
+	c.Lock()
+	c.pending = make(map[coord]*heightChunk)
+	c.Unlock()
+
+	legend := []LegendEntry{}
+	for _, stop := range c.ramp {
+		legend = append(legend, LegendEntry{Name: fmt.Sprintf("y %d", stop.y), Color: formatHexColor(stop.color)})
+	}
+
+	data, err := json.Marshal(legend)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(path, c.LegendFile()), data, 0644)
+}
+
+func (c *HeightRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error) {
+	if len(chunk.Sections) == 0 {
+		return nil, nil
+	}
+
+	img := image.NewRGBA64(image.Rect(0, 0, 16, 16))
+	heights := chunkHeightmap(chunk, c.heightmap)
+	minY := chunkMinY(chunk)
+
+	for x := 0; x < 16; x++ {
+		for z := 0; z < 16; z++ {
+			// heightmaps hold the first free block above the surface, zero
+			// for columns without any blocks
+			height := heights.Get((z * 16) + x)
+			if height == 0 {
+				continue
+			}
+			img.Set(x, z, c.ramp.colorAt(height+minY-1))
+		}
+	}
+
+	if c.contourInterval > 0 {
+		c.Lock()
+		c.pending[coord{X: int(chunk.XPos), Z: int(chunk.ZPos)}] = &heightChunk{minY: minY, heights: heights}
+		c.Unlock()
+	}
+
+	return img, nil
+}
+
+// FinishMargin covers the neighbors contour lines compare heights with
+func (c *HeightRenderer) FinishMargin() int {
+	if c.contourInterval == 0 {
+		return 0
+	}
+	return 1
+}
+
+// StoreRegion keeps the heights of a region in a sidecar so contour lines along
+// its edges can be drawn against it
+func (c *HeightRenderer) StoreRegion(dst string, x, z int, drawn []coord) error {
+	c.Lock()
+	chunks := takeRegionChunks(c.pending, x, z)
+	c.Unlock()
+
+	return storeRegionColumns(dst, x, z, drawn, chunks, func(columns *regionColumns, crd coord, chunk *heightChunk) {
+		columns.setChunk(crd, chunk.minY, chunk.heights, nil, nil)
+
+		// columns without any blocks are outside every contour band
+		for idx := 0; idx < 256; idx++ {
+			if chunk.heights.Get(idx) == 0 {
+				columns.Heights[columnIndex(crd, idx)] = noHeight
+			}
+		}
+	})
+}
+
+// FinishRegion draws the contour lines of the chunks drawn in this pass
+func (c *HeightRenderer) FinishRegion(dst string, x, z int, img draw.Image, drawn []coord) error {
+	if c.contourInterval == 0 {
+		return nil
+	}
+
+	columns := loadRegionColumns(dst, x, z)
+	if columns == nil {
+		return fmt.Errorf("failed to load region columns (%v, %v)", x, z)
+	}
+	neighborhood := newRegionNeighborhood(dst, x, z, columns)
+
+	// the contour band a column falls in, columns without blocks have none
+	band := func(x, z int) (int, bool) {
+		height, ok := neighborhood.height(x, z)
+		if !ok {
+			return 0, false
+		}
+		return int(math.Floor(float64(height-1) / float64(c.contourInterval))), true
+	}
+
+	contour := image.NewUniform(c.contourColor)
+	for _, crd := range drawn {
+		for idx := 0; idx < 256; idx++ {
+			px, pz := crd.X*16+idx%16, crd.Z*16+idx/16
+			own, ok := band(px, pz)
+			if !ok {
+				continue
+			}
+
+			// lines are drawn on the upper side of a band boundary so they
+			// stay one pixel wide
+			for _, offset := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				other, ok := band(px+offset[0], pz+offset[1])
+				if ok && other < own {
+					draw.Draw(img, image.Rect(px, pz, px+1, pz+1), contour, image.Point{}, draw.Over)
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
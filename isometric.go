@@ -0,0 +1,365 @@
+package carto
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/Tnze/go-mc/save"
+)
+
+// isometricMinY and isometricWorldHeight are the range of blocks with vertical
+// space reserved in each chunk image, enough to fit a full 1.18+ world
+const (
+	isometricMinY        = -64
+	isometricWorldHeight = 384
+)
+
+const (
+	isoFaceNone = iota
+	isoFaceTop
+	isoFaceLeft
+	isoFaceRight
+)
+
+// IsometricRenderer draws the visible faces of every block in a chunk using a
+// 2:1 isometric projection, looking down from the south east
+type IsometricRenderer struct {
+	palette   *Palette
+	blockSize int
+
+	// sprite is a blockSize x blockSize mask of which face each pixel belongs to
+	sprite []int
+
+	// spriteUV is the position within its face of each sprite pixel, from 0 to 1
+	spriteUV [][2]float64
+}
+
+type isometricBlock struct {
+	X, Y, Z int
+	Top     color.Color
+	Side    color.Color
+
+	// TopTexture and SideTexture are drawn instead of the flat colors when set,
+	// multiplied by Tint for tinted blocks
+	TopTexture  image.Image
+	SideTexture image.Image
+	Tint        color.Color
+	SideTint    color.Color
+}
+
+func init() {
+	RegisterRenderer(RendererInfo{
+		Name:        "isometric",
+		Description: "3d isometric view of block faces, use in its own map as the tiles do not line up with top-down layers",
+		Options: []RendererOption{
+			{Name: "block-size", Default: "4", Description: "width in pixels of a single block, must be a multiple of 4"},
+			{Name: "unknown-color", Default: "#ff00ff", Description: "color drawn for blocks missing from the assets, none to skip them"},
+		},
+		Factory: func(opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error) {
+			renderer, err := NewIsometricRenderer(opts, assetLoader)
+			if err != nil {
+				return nil, err
+			}
+			return renderer, nil
+		},
+	})
+}
+
+func NewIsometricRenderer(opts *ChunkRenderOpts, assetLoader *AssetLoader) (*IsometricRenderer, error) {
+	blockSize := opts.GetInt("block-size", 4)
+	if blockSize < 4 {
+		blockSize = 4
+	}
+	blockSize -= blockSize % 4
+
+	palette, err := NewPalette(assetLoader)
+	if err != nil {
+		return nil, err
+	}
+	palette.placeholder = opts.GetColor("unknown-color", DefaultPlaceholderColor)
+
+	sprite, spriteUV := newIsometricSprite(blockSize)
+	return &IsometricRenderer{
+		palette:   palette,
+		blockSize: blockSize,
+		sprite:    sprite,
+		spriteUV:  spriteUV,
+	}, nil
+}
+
+func (c *IsometricRenderer) GetMissingBlockStates() []string {
+	return c.palette.GetMissingBlockStates()
+}
+
+func (c *IsometricRenderer) GetMissingBiomes() []string {
+	return c.palette.GetMissingBiomes()
+}
+
+// newIsometricSprite builds the face mask used to draw a single block along
+// with where each pixel falls on the texture of its face. The top face spans
+// from the north west corner at the top of the diamond to the south east
+// corner at its bottom, the left face is the south face and the right face is
+// the east face, both seen from outside with up at the top.
+func newIsometricSprite(size int) ([]int, [][2]float64) {
+	half := float64(size) / 2
+	quarter := float64(size) / 4
+
+	sprite := make([]int, size*size)
+	uv := make([][2]float64, size*size)
+	for px := 0; px < size; px++ {
+		for py := 0; py < size; py++ {
+			x, y := float64(px)+0.5, float64(py)+0.5
+
+			face := isoFaceNone
+			var u, v float64
+			if abs(x-half)/half+abs(y-quarter)/quarter <= 1 {
+				face = isoFaceTop
+				u = ((x-half)/half + y/quarter) / 2
+				v = (y/quarter - (x-half)/half) / 2
+			} else if x < half {
+				edge := quarter + x/2
+				if y >= edge && y <= edge+half {
+					face = isoFaceLeft
+					u, v = x/half, (y-edge)/half
+				}
+			} else {
+				edge := half - (x-half)/2
+				if y >= edge && y <= edge+half {
+					face = isoFaceRight
+					u, v = (x-half)/half, (y-edge)/half
+				}
+			}
+			sprite[py*size+px] = face
+			uv[py*size+px] = [2]float64{u, v}
+		}
+	}
+	return sprite, uv
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (c *IsometricRenderer) Finalize(path string) error {
+	return nil
+}
+
+func (c *IsometricRenderer) Projection() string {
+	return "isometric"
+}
+
+func (c *IsometricRenderer) ImageSize() (int, int) {
+	return 16 * c.blockSize, 30*c.blockSize/4 + (isometricWorldHeight-1)*c.blockSize/2 + c.blockSize
+}
+
+func (c *IsometricRenderer) RegionImageSize() (int, int) {
+	width, height := c.ImageSize()
+	return width + 62*8*c.blockSize, height + 62*4*c.blockSize
+}
+
+func (c *IsometricRenderer) ChunkImageOffset(x, z int) image.Point {
+	return image.Point{
+		X: (x - z + 31) * 8 * c.blockSize,
+		Y: (x + z) * 4 * c.blockSize,
+	}
+}
+
+func (c *IsometricRenderer) RegionImageOrigin(x, z int) image.Point {
+	return image.Point{
+		X: (x-z)*256*c.blockSize - 511*c.blockSize/2,
+		Y: (x + z) * 128 * c.blockSize,
+	}
+}
+
+func (c *IsometricRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error) {
+	if len(chunk.Sections) == 0 {
+		return nil, nil
+	}
+
+	cache := newSectionCache(c.palette, chunk)
+
+	blockAt := func(x, y, z int) (save.BlockState, save.BiomeState, bool) {
+		sc := cache.getBlock(y)
+		if sc == nil || len(sc.section.BlockStates.Palette) == 0 {
+			return save.BlockState{}, "", false
+		}
+
+		blockIndex := blockIndex(x, y, z)
+		blockState := sc.section.BlockStates.Palette[sc.storage.Get(blockIndex)]
+		if isAirBlock(blockState.Name) {
+			return blockState, "", false
+		}
+		return blockState, sc.biome(x, y, z), true
+	}
+
+	// only blocks with an exposed top or front face can be seen
+	isOpen := func(x, y, z int) bool {
+		if x > 15 || z > 15 || y > cache.maxY {
+			return true
+		}
+		_, _, solid := blockAt(x, y, z)
+		return !solid
+	}
+
+	minY := cache.minY
+	if minY < isometricMinY {
+		minY = isometricMinY
+	}
+
+	maxY := cache.maxY
+	if maxY >= isometricMinY+isometricWorldHeight {
+		maxY = isometricMinY + isometricWorldHeight - 1
+	}
+
+	blocks := []isometricBlock{}
+	for y := minY; y <= maxY; y++ {
+		for x := 0; x < 16; x++ {
+			for z := 0; z < 16; z++ {
+				blockState, biomeState, solid := blockAt(x, y, z)
+				if !solid {
+					continue
+				}
+
+				if !isOpen(x, y+1, z) && !isOpen(x+1, y, z) && !isOpen(x, y, z+1) {
+					continue
+				}
+
+				top := c.palette.GetColor(blockState, biomeState)
+				side := c.palette.GetSideColor(blockState, biomeState)
+				if top == nil || side == nil {
+					continue
+				}
+
+				block := isometricBlock{X: x, Y: y, Z: z, Top: top, Side: side}
+				block.TopTexture, block.SideTexture = c.palette.GetFaceTextures(blockState)
+				block.Tint, block.SideTint = c.palette.GetTints(blockState, biomeState)
+				blocks = append(blocks, block)
+			}
+		}
+	}
+
+	// painters algorithm, blocks further from the viewer are drawn first
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].X+blocks[i].Y+blocks[i].Z < blocks[j].X+blocks[j].Y+blocks[j].Z
+	})
+
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+
+	// the image only covers the rows blocks are drawn in, most of the world
+	// height is empty
+	minSY, maxSY := math.MaxInt, math.MinInt
+	for _, block := range blocks {
+		_, sy := c.blockOffset(block)
+		minSY = min(minSY, sy)
+		maxSY = max(maxSY, sy+c.blockSize)
+	}
+
+	width, _ := c.ImageSize()
+	img := image.NewRGBA(image.Rect(0, minSY, width, maxSY))
+	for _, block := range blocks {
+		c.drawBlock(img, block)
+	}
+
+	return img, nil
+}
+
+// blockOffset returns where the sprite of a block is drawn within its chunk image
+func (c *IsometricRenderer) blockOffset(block isometricBlock) (int, int) {
+	sx := (block.X - block.Z + 15) * c.blockSize / 2
+	sy := (block.X+block.Z)*c.blockSize/4 + (isometricWorldHeight-1-(block.Y-isometricMinY))*c.blockSize/2
+	return sx, sy
+}
+
+// drawBlock draws the top and both front faces of a block, the left face is
+// lit slightly and the right face is in shadow
+func (c *IsometricRenderer) drawBlock(img *image.RGBA, block isometricBlock) {
+	sx, sy := c.blockOffset(block)
+
+	faces := [4]color.RGBA{
+		isoFaceTop:   toRGBA(block.Top, 1.0),
+		isoFaceLeft:  toRGBA(block.Side, 0.8),
+		isoFaceRight: toRGBA(block.Side, 0.6),
+	}
+	brightness := [4]float64{isoFaceTop: 1.0, isoFaceLeft: 0.8, isoFaceRight: 0.6}
+
+	for py := 0; py < c.blockSize; py++ {
+		for px := 0; px < c.blockSize; px++ {
+			face := c.sprite[py*c.blockSize+px]
+			if face == isoFaceNone {
+				continue
+			}
+
+			clr := faces[face]
+			texture, tint := block.SideTexture, block.SideTint
+			if face == isoFaceTop {
+				texture, tint = block.TopTexture, block.Tint
+			}
+			if texture != nil {
+				uv := c.spriteUV[py*c.blockSize+px]
+				clr = toRGBA(sampleTexture(texture, uv, tint), brightness[face])
+			}
+
+			if clr.A == 0 {
+				continue
+			} else if clr.A == 255 {
+				img.SetRGBA(sx+px, sy+py, clr)
+			} else {
+				img.SetRGBA(sx+px, sy+py, combineOver(img.RGBAAt(sx+px, sy+py), clr))
+			}
+		}
+	}
+}
+
+// sampleTexture returns the texture pixel at a position from 0 to 1 across it,
+// multiplied by tint if set. Animated textures are sampled from their first frame.
+func sampleTexture(texture image.Image, uv [2]float64, tint color.Color) color.Color {
+	bounds := texture.Bounds()
+	size := bounds.Dx()
+
+	x := clampInt(int(uv[0]*float64(size)), 0, size-1)
+	y := clampInt(int(uv[1]*float64(size)), 0, size-1)
+	clr := texture.At(bounds.Min.X+x, bounds.Min.Y+y)
+	if tint == nil {
+		return clr
+	}
+
+	r, g, b, a := clr.RGBA()
+	tr, tg, tb, _ := tint.RGBA()
+	return color.RGBA64{
+		R: uint16(r * tr / 0xffff),
+		G: uint16(g * tg / 0xffff),
+		B: uint16(b * tb / 0xffff),
+		A: uint16(a),
+	}
+}
+
+// toRGBA converts a color to 8-bit RGBA, scaling its brightness
+func toRGBA(clr color.Color, brightness float64) color.RGBA {
+	r, g, b, a := clr.RGBA()
+
+	// colors are alpha-premultiplied, so brightness is scaled on all but alpha
+	return color.RGBA{
+		R: uint8(float64(r>>8) * brightness),
+		G: uint8(float64(g>>8) * brightness),
+		B: uint8(float64(b>>8) * brightness),
+		A: uint8(a >> 8),
+	}
+}
+
+// combineOver composites a premultiplied color over another
+func combineOver(dst, src color.RGBA) color.RGBA {
+	inv := 255 - uint32(src.A)
+	return color.RGBA{
+		R: uint8(uint32(src.R) + uint32(dst.R)*inv/255),
+		G: uint8(uint32(src.G) + uint32(dst.G)*inv/255),
+		B: uint8(uint32(src.B) + uint32(dst.B)*inv/255),
+		A: uint8(uint32(src.A) + uint32(dst.A)*inv/255),
+	}
+}
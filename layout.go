@@ -0,0 +1,232 @@
+package carto
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// layoutTileSize is the size of the square tiles that region images are stitched into
+const layoutTileSize = 512
+
+// layoutRegionCacheSize is the number of decoded region images kept in memory while stitching
+const layoutRegionCacheSize = 16
+
+// RegionLayout is implemented by ChunkRenderers whose chunk images do not sit on
+// a simple grid. Region images are drawn back-to-front and later stitched into
+// square map tiles.
+type RegionLayout interface {
+	// RegionImageSize returns the width and height of a single region image
+	RegionImageSize() (int, int)
+	// ChunkImageOffset returns where a chunk image is drawn within its region image
+	ChunkImageOffset(x, z int) image.Point
+	// RegionImageOrigin returns where a region image is placed on the whole map
+	RegionImageOrigin(x, z int) image.Point
+	// Projection is the name of the projection used, passed along to the frontend
+	Projection() string
+}
+
+// GetProjection returns the name of the projection used by a ChunkRenderer
+func GetProjection(chunk ChunkRenderer) string {
+	if layout, ok := chunk.(RegionLayout); ok {
+		return layout.Projection()
+	}
+	return "topdown"
+}
+
+// regionImageBounds returns the bounds of a region image on the whole map
+func regionImageBounds(layout RegionLayout, crd coord) image.Rectangle {
+	width, height := layout.RegionImageSize()
+	return image.Rect(0, 0, width, height).Add(layout.RegionImageOrigin(crd.X, crd.Z))
+}
+
+// layoutTilesFor returns the coordinates of every tile overlapped by a rectangle
+func layoutTilesFor(bounds image.Rectangle) []coord {
+	minX := int(math.Floor(float64(bounds.Min.X) / layoutTileSize))
+	minZ := int(math.Floor(float64(bounds.Min.Y) / layoutTileSize))
+	maxX := int(math.Floor(float64(bounds.Max.X-1) / layoutTileSize))
+	maxZ := int(math.Floor(float64(bounds.Max.Y-1) / layoutTileSize))
+
+	result := []coord{}
+	for x := minX; x <= maxX; x++ {
+		for z := minZ; z <= maxZ; z++ {
+			result = append(result, coord{X: x, Z: z})
+		}
+	}
+	return result
+}
+
+// stitchLayoutTiles composites the region images in regionPath into square tiles
+// in tilePath, only regenerating tiles which overlap a changed region. It
+// returns the coordinates of every tile that was updated.
+func stitchLayoutTiles(layout RegionLayout, regionPath, tilePath string, changed map[coord]struct{}) (map[coord]struct{}, error) {
+	changedTiles := make(map[coord]struct{})
+	for crd := range changed {
+		for _, tile := range layoutTilesFor(regionImageBounds(layout, crd)) {
+			changedTiles[tile] = struct{}{}
+		}
+	}
+
+	allRegions, err := listRegionImages(regionPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tileRegions := make(map[coord][]coord)
+	for crd := range allRegions {
+		for _, tile := range layoutTilesFor(regionImageBounds(layout, crd)) {
+			if _, ok := changedTiles[tile]; ok {
+				tileRegions[tile] = append(tileRegions[tile], crd)
+			}
+		}
+	}
+
+	tiles := make([]coord, 0, len(changedTiles))
+	for tile := range changedTiles {
+		tiles = append(tiles, tile)
+	}
+
+	// walking the tiles in order keeps the region image cache warm
+	sort.Slice(tiles, func(i, j int) bool {
+		if tiles[i].Z != tiles[j].Z {
+			return tiles[i].Z < tiles[j].Z
+		}
+		return tiles[i].X < tiles[j].X
+	})
+
+	cache := newRegionImageCache(regionPath, layoutRegionCacheSize)
+	guard := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	var wg sync.WaitGroup
+	var firstErr error
+	var errLock sync.Mutex
+	for _, tile := range tiles {
+		guard <- struct{}{}
+		wg.Add(1)
+		go func(tile coord) {
+			defer wg.Done()
+			defer func() {
+				<-guard
+			}()
+
+			err := stitchLayoutTile(layout, cache, tilePath, tile, tileRegions[tile])
+			if err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errLock.Unlock()
+			}
+		}(tile)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return changedTiles, nil
+}
+
+// stitchLayoutTile composites every region overlapping a tile back-to-front and writes the tile
+func stitchLayoutTile(layout RegionLayout, cache *regionImageCache, tilePath string, tile coord, regions []coord) error {
+	dst := tileImagePath(tilePath, RegionTileZoom, tile)
+
+	if len(regions) == 0 {
+		err := os.Remove(dst)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		a, b := regions[i], regions[j]
+		if a.X+a.Z != b.X+b.Z {
+			return a.X+a.Z < b.X+b.Z
+		}
+		return a.X < b.X
+	})
+
+	tileOrigin := image.Point{tile.X * layoutTileSize, tile.Z * layoutTileSize}
+	img := image.NewRGBA(image.Rect(0, 0, layoutTileSize, layoutTileSize))
+
+	for _, crd := range regions {
+		regionImg, err := cache.get(crd)
+		if err != nil {
+			return err
+		}
+
+		bounds := regionImageBounds(layout, crd).Sub(tileOrigin)
+		draw.Draw(img, bounds, regionImg, regionImg.Bounds().Min, draw.Over)
+	}
+
+	fd, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if err := png.Encode(fd, img); err != nil {
+		return fmt.Errorf("failed to encode tile png %s: %v", dst, err)
+	}
+
+	return nil
+}
+
+// regionImageCache keeps a bounded number of decoded region images in memory
+type regionImageCache struct {
+	sync.Mutex
+
+	path  string
+	size  int
+	order []coord
+	items map[coord]image.Image
+}
+
+func newRegionImageCache(path string, size int) *regionImageCache {
+	return &regionImageCache{
+		path:  path,
+		size:  size,
+		items: make(map[coord]image.Image),
+	}
+}
+
+func (c *regionImageCache) get(crd coord) (image.Image, error) {
+	c.Lock()
+	img, ok := c.items[crd]
+	c.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	fd, err := os.Open(regionImagePath(c.path, crd))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	img, err = png.Decode(fd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode region image (%v, %v): %v", crd.X, crd.Z, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.items[crd]; !ok {
+		c.items[crd] = img
+		c.order = append(c.order, crd)
+		if len(c.order) > c.size {
+			delete(c.items, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+
+	return img, nil
+}
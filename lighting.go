@@ -1,19 +1,73 @@
 package carto
 
 import (
+	"fmt"
 	"image"
 	"image/color"
-	"math/bits"
+	"slices"
+	"strings"
 
-	"github.com/Tnze/go-mc/level"
 	"github.com/Tnze/go-mc/save"
 )
 
+// lightingModes are the ways the lighting renderer combines sky and block light
+var lightingModes = []string{"night", "day", "spawnable"}
+
+// nightSkyDarken is how much the sky light level drops at midnight
+const nightSkyDarken = 11
+
+// maxSpawnLight is the highest light level hostile mobs spawn at, block light
+// must also be zero
+const maxSpawnLight = 7
+
+// dataVersion118 is the first chunk data version of 1.18, which leaves sky
+// light out of sections the sky fully lights
+const dataVersion118 = 2860
+
 type LightingRenderer struct {
+	mode string
+
+	// skyLight is false for dimensions without a sky, like the nether
+	skyLight bool
+
+	// maxDarkness is the alpha drawn over columns with a light level of zero
+	maxDarkness uint8
+	highlight   color.Color
+}
+
+func init() {
+	RegisterRenderer(RendererInfo{
+		Name:        "light",
+		Description: "overlay darkening each column by the light level above its surface",
+		Options: []RendererOption{
+			{Name: "mode", Default: "night", Description: "night or day combine sky and block light for that time, spawnable highlights surfaces where hostile mobs can spawn at night"},
+			{Name: "sky-light", Default: "true", Description: "false for dimensions without a sky, set for the nether and end by default"},
+			{Name: "max-darkness", Default: "192", Description: "alpha drawn over columns with no light, 0-255"},
+			{Name: "highlight-color", Default: "#ff000099", Description: "color of spawnable surfaces, #rrggbbaa for transparency"},
+		},
+		Factory: func(opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error) {
+			renderer, err := NewLightingRenderer(opts)
+			if err != nil {
+				return nil, err
+			}
+			return renderer, nil
+		},
+	})
 }
 
-func NewLightingRenderer() *LightingRenderer {
-	return &LightingRenderer{}
+func NewLightingRenderer(opts *ChunkRenderOpts) (*LightingRenderer, error) {
+	mode := opts.GetString("mode", "night")
+
+	if !slices.Contains(lightingModes, mode) {
+		return nil, fmt.Errorf("unknown lighting mode '%s' (available: %s)", mode, strings.Join(lightingModes, ", "))
+	}
+
+	return &LightingRenderer{
+		mode:        mode,
+		skyLight:    opts.GetBool("sky-light", true),
+		maxDarkness: uint8(clampInt(opts.GetInt("max-darkness", 192), 0, 255)),
+		highlight:   opts.GetColor("highlight-color", color.NRGBA{R: 0xff, A: 0x99}),
+	}, nil
 }
 
 func (c *LightingRenderer) Finalize(path string) error {
@@ -24,39 +78,88 @@ func (c *LightingRenderer) ImageSize() (int, int) {
 	return 16, 16
 }
 
+// lightNibble reads a 4 bit light level from a sections light array
+func lightNibble(data []byte, x, y, z int) byte {
+	index := blockIndex(x, y, z)
+	raw := data[index/2]
+	if index&1 > 0 {
+		return (raw >> 4) & 0x0f
+	}
+	return raw & 0x0f
+}
+
+// lightAt returns the sky and block light at a block, positions above every
+// section are open to the sky. Sections without sky light data are only lit
+// when exposed is set, as the game leaves it out of sections the sky fully
+// lights.
+func lightAt(cache *sectionCache, x, y, z int, skyLight, exposed bool) (sky byte, block byte) {
+	sc := cache.getBlock(y)
+	if sc == nil {
+		if skyLight {
+			return 15, 0
+		}
+		return 0, 0
+	}
+
+	if skyLight && len(sc.section.SkyLight) == 2048 {
+		sky = lightNibble(sc.section.SkyLight, x, y, z)
+	} else if skyLight && exposed {
+		sky = 15
+	}
+	if len(sc.section.BlockLight) == 2048 {
+		block = lightNibble(sc.section.BlockLight, x, y, z)
+	}
+	return sky, block
+}
+
+// isSpawnableSurface reports whether mobs could stand on a block, fluids and
+// leaves never allow spawns
+func isSpawnableSurface(name string) bool {
+	return name != "minecraft:water" && name != "minecraft:lava" && !strings.HasSuffix(name, "_leaves") && !isAirBlock(name)
+}
+
 func (c *LightingRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error) {
 	img := image.NewRGBA64(image.Rect(0, 0, 16, 16))
-	bitsForHeight := bits.Len(uint(len(chunk.Sections))*16 + 1)
-	motionBlocking := level.NewBitStorage(bitsForHeight, 16*16, chunk.Heightmaps["MOTION_BLOCKING"])
+	motionBlocking := chunkHeightmap(chunk, "MOTION_BLOCKING")
+	worldSurface := chunkHeightmap(chunk, "WORLD_SURFACE")
+	cache := newSectionCache(nil, chunk)
+	omitsSkyLight := chunk.DataVersion >= dataVersion118
 
 	for x := 0; x < 16; x++ {
 		for z := 0; z < 16; z++ {
 			heightmapIndex := ((z) * 16) + x
-			yStart := motionBlocking.Get(heightmapIndex)
-			sectionY := yStart / 16
 
-			section := chunk.Sections[sectionY]
+			// the heightmap holds the first free block above the surface,
+			// which is where the light the surface receives is stored
+			y := motionBlocking.Get(heightmapIndex) + cache.minY
 
-			blockLight := byte(0)
-			if len(section.BlockLight) > 0 {
-				blockLightIndex := x + ((sectionY & 0x0f) << 8) + (z << 4)
-				blockLightRaw := section.BlockLight[blockLightIndex/2]
+			// nothing above the world surface, not even glass, blocks the sky
+			exposed := omitsSkyLight && y >= worldSurface.Get(heightmapIndex)+cache.minY
+			sky, block := lightAt(cache, x, y, z, c.skyLight, exposed)
+			if c.mode != "day" {
+				sky = byte(max(int(sky)-nightSkyDarken, 0))
+			}
+			level := max(sky, block)
 
-				if blockLightIndex&1 > 0 {
-					blockLight = (blockLightRaw >> 4) & 0x0F
-				} else {
-					blockLight = (blockLightRaw & 0x0F)
+			switch c.mode {
+			case "spawnable":
+				if block != 0 || level > maxSpawnLight {
+					continue
 				}
 
+				sc := cache.getBlock(y - 1)
+				if sc == nil || len(sc.section.BlockStates.Palette) == 0 {
+					continue
+				}
+				surface := sc.section.BlockStates.Palette[sc.storage.Get(blockIndex(x, y-1, z))]
+				if isSpawnableSurface(surface.Name) {
+					img.Set(x, z, c.highlight)
+				}
+			default:
+				img.Set(x, z, color.RGBA{
+					A: uint8(int(c.maxDarkness) * int(15-level) / 15),
+				})
 			}
-
-			a := 192 - ((blockLight + 1) * 12)
-			img.Set(x, z, color.RGBA{
-				R: 0,
-				G: 0,
-				B: 0,
-				A: a,
-			})
 		}
 	}
 
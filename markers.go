@@ -0,0 +1,535 @@
+package carto
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Tnze/go-mc/level"
+	"github.com/Tnze/go-mc/nbt"
+	"github.com/Tnze/go-mc/save"
+	"github.com/Tnze/go-mc/save/region"
+)
+
+const (
+	MarkerPlayer    = "player"
+	MarkerSpawn     = "spawn"
+	MarkerSign      = "sign"
+	MarkerBanner    = "banner"
+	MarkerLodestone = "lodestone"
+)
+
+// MarkerTypes is every type of marker which can be extracted from a world
+var MarkerTypes = []string{MarkerPlayer, MarkerSpawn, MarkerSign, MarkerBanner, MarkerLodestone}
+
+// DefaultMarkerTypes are published when a map does not configure its markers,
+// player positions are left out as they are usually private
+var DefaultMarkerTypes = []string{MarkerSpawn, MarkerSign, MarkerBanner, MarkerLodestone}
+
+// Marker is a single point of interest shown on top of the map
+type Marker struct {
+	Type string   `json:"type"`
+	Name string   `json:"name,omitempty"`
+	Text []string `json:"text,omitempty"`
+	X    int      `json:"x"`
+	Y    int      `json:"y"`
+	Z    int      `json:"z"`
+}
+
+// MarkerMeta caches the markers found in each region file so unchanged regions
+// do not need to be read again
+type MarkerMeta struct {
+	Types []string
+
+	// Area is the area markers were extracted for, regions only hold markers
+	// from chunks within it
+	Area *RenderArea
+
+	Regions map[string]RegionMarkers
+}
+
+type RegionMarkers struct {
+	ModTime int64
+	Markers []Marker
+}
+
+type MarkerOpts struct {
+	// Types is the set of marker types to extract
+	Types []string
+
+	// WorldPath is the root of the world save, players and spawn are only
+	// extracted when it is set
+	WorldPath string
+
+	// Dimension is the id of the dimension being extracted
+	Dimension string
+
+	// Area drops markers outside of the rendered area, may be nil
+	Area *RenderArea
+
+	// Previous is the result of the last extraction, may be nil
+	Previous *MarkerMeta
+}
+
+type blockEntity struct {
+	ID         string         `nbt:"id"`
+	X          int32          `nbt:"x"`
+	Y          int32          `nbt:"y"`
+	Z          int32          `nbt:"z"`
+	CustomName nbt.RawMessage `nbt:"CustomName"`
+
+	// signs from 1.20 onwards store text for both sides
+	FrontText struct {
+		Messages []nbt.RawMessage `nbt:"messages"`
+	} `nbt:"front_text"`
+
+	// signs before 1.20
+	Text1 string
+	Text2 string
+	Text3 string
+	Text4 string
+}
+
+// ExtractMarkers finds all the markers of the requested types within a
+// dimension, returning the markers and updated metadata for the next run
+func ExtractMarkers(regionPath string, opts MarkerOpts) ([]Marker, *MarkerMeta, error) {
+	types := make(map[string]bool)
+	for _, t := range opts.Types {
+		types[t] = true
+	}
+
+	meta := &MarkerMeta{
+		Types:   opts.Types,
+		Area:    opts.Area,
+		Regions: make(map[string]RegionMarkers),
+	}
+
+	// a change in types means the cached markers are missing or hold private
+	// data, a change in area that they miss chunks which are now within it
+	previous := opts.Previous
+	if previous != nil && (strings.Join(previous.Types, ",") != strings.Join(opts.Types, ",") || !sameArea(previous.Area, opts.Area)) {
+		previous = nil
+	}
+
+	result := []Marker{}
+	if types[MarkerSign] || types[MarkerBanner] || types[MarkerLodestone] {
+		files, err := listRegionFiles(regionPath, opts.Area)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, file := range files {
+			if filepath.Ext(file.name) != ".mca" {
+				continue
+			}
+
+			path := filepath.Join(regionPath, file.name)
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, nil, err
+			} else if info.IsDir() {
+				continue
+			}
+
+			if previous != nil {
+				if cached, ok := previous.Regions[file.regionName]; ok && cached.ModTime == info.ModTime().Unix() {
+					meta.Regions[file.regionName] = cached
+					result = append(result, cached.Markers...)
+					continue
+				}
+			}
+
+			// chunks of regions without coordinates in their name cannot be
+			// placed before they are read
+			inArea := func(x, z int) bool {
+				return file.crdErr != nil || opts.Area.containsChunk(file.crd.X*32+x, file.crd.Z*32+z)
+			}
+
+			markers, err := extractRegionMarkers(path, types, inArea)
+			if err != nil {
+				log.Printf("[markers] failed to read region file %s: %v", path, err)
+				continue
+			}
+
+			meta.Regions[file.regionName] = RegionMarkers{
+				ModTime: info.ModTime().Unix(),
+				Markers: markers,
+			}
+			result = append(result, markers...)
+		}
+	}
+
+	if opts.WorldPath != "" && types[MarkerSpawn] && opts.Dimension == DimensionOverworld {
+		// worlds without level.dat still get their other markers
+		spawn, err := loadSpawnMarker(opts.WorldPath)
+		if err != nil {
+			log.Printf("[markers] skipping spawn marker: %v", err)
+		} else {
+			result = append(result, spawn)
+		}
+	}
+
+	if opts.WorldPath != "" && types[MarkerPlayer] {
+		players, err := loadPlayerMarkers(opts.WorldPath, opts.Dimension)
+		if err != nil {
+			log.Printf("[markers] skipping player markers: %v", err)
+		} else {
+			result = append(result, players...)
+		}
+	}
+
+	// chunks partially within the area may hold markers outside of it
+	if opts.Area != nil {
+		result = slices.DeleteFunc(result, func(marker Marker) bool {
+			return !opts.Area.containsBlock(marker.X, marker.Z)
+		})
+	}
+
+	return result, meta, nil
+}
+
+// sameArea reports whether two areas cover the same chunks, they are compared
+// as stored in the metadata
+func sameArea(a, b *RenderArea) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
+
+// extractRegionMarkers reads the markers of the chunks of a region for which
+// inArea returns true, chunks which fail to decode are skipped
+func extractRegionMarkers(path string, types map[string]bool, inArea func(x, z int) bool) ([]Marker, error) {
+	reg, err := region.Open(path)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer reg.Close()
+
+	result := []Marker{}
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			if !reg.ExistSector(x, z) || !inArea(x, z) {
+				continue
+			}
+
+			sector, err := reg.ReadSector(x, z)
+			if errors.Is(err, region.ErrNoSector) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			var chunk save.Chunk
+			err = chunk.Load(sector)
+			if err != nil {
+				log.Printf("[markers] failed to decode chunk (%v, %v) of %s: %v", x, z, path, err)
+				continue
+			}
+
+			result = append(result, extractChunkMarkers(&chunk, types)...)
+		}
+	}
+	return result, nil
+}
+
+func extractChunkMarkers(chunk *save.Chunk, types map[string]bool) []Marker {
+	result := []Marker{}
+	for _, raw := range chunk.BlockEntities {
+		var entity blockEntity
+		if err := raw.Unmarshal(&entity); err != nil {
+			continue
+		}
+
+		switch {
+		case types[MarkerSign] && (strings.HasSuffix(entity.ID, "_sign") || entity.ID == "minecraft:sign"):
+			text := signText(&entity)
+			if len(text) == 0 {
+				continue
+			}
+
+			result = append(result, Marker{
+				Type: MarkerSign,
+				Text: text,
+				X:    int(entity.X),
+				Y:    int(entity.Y),
+				Z:    int(entity.Z),
+			})
+		case types[MarkerBanner] && entity.ID == "minecraft:banner":
+			// like vanilla maps, only named banners are shown
+			name := textComponentString(entity.CustomName)
+			if name == "" {
+				continue
+			}
+
+			result = append(result, Marker{
+				Type: MarkerBanner,
+				Name: name,
+				X:    int(entity.X),
+				Y:    int(entity.Y),
+				Z:    int(entity.Z),
+			})
+		}
+	}
+
+	if types[MarkerLodestone] {
+		result = append(result, findBlocks(chunk, "minecraft:lodestone", MarkerLodestone)...)
+	}
+
+	return result
+}
+
+// findBlocks returns a marker for every block of the given name in a chunk,
+// only sections which include the block in their palette are scanned
+func findBlocks(chunk *save.Chunk, name, markerType string) []Marker {
+	result := []Marker{}
+	for _, section := range chunk.Sections {
+		paletteIndex := -1
+		for idx, state := range section.BlockStates.Palette {
+			if state.Name == name {
+				paletteIndex = idx
+				break
+			}
+		}
+
+		if paletteIndex == -1 {
+			continue
+		}
+
+		bits := calcBitsPerValue(16*16*16, len(section.BlockStates.Data))
+		var storage *level.BitStorage
+		if bits != 0 {
+			storage = level.NewBitStorage(bits, 16*16*16, section.BlockStates.Data)
+		}
+
+		for i := 0; i < 16*16*16; i++ {
+			if storage != nil && storage.Get(i) != paletteIndex {
+				continue
+			}
+
+			result = append(result, Marker{
+				Type: markerType,
+				X:    int(chunk.XPos)*16 + i%16,
+				Y:    int(section.Y)*16 + i/256,
+				Z:    int(chunk.ZPos)*16 + (i/16)%16,
+			})
+		}
+	}
+	return result
+}
+
+// signText returns the non-empty lines on the front of a sign
+func signText(entity *blockEntity) []string {
+	lines := []string{}
+	if len(entity.FrontText.Messages) > 0 {
+		for _, msg := range entity.FrontText.Messages {
+			lines = append(lines, textComponentString(msg))
+		}
+	} else {
+		for _, line := range []string{entity.Text1, entity.Text2, entity.Text3, entity.Text4} {
+			lines = append(lines, textComponentPlain(line))
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// textComponentString converts a text component stored in NBT into plain text,
+// components are either a JSON string or, in newer versions, an NBT compound
+func textComponentString(msg nbt.RawMessage) string {
+	switch msg.Type {
+	case nbt.TagString:
+		var value string
+		if err := msg.Unmarshal(&value); err != nil {
+			return ""
+		}
+		return textComponentPlain(value)
+	case nbt.TagCompound:
+		var value map[string]any
+		if err := msg.Unmarshal(&value); err != nil {
+			return ""
+		}
+		return textComponentText(value)
+	}
+	return ""
+}
+
+// textComponentPlain converts a JSON text component into plain text
+func textComponentPlain(value string) string {
+	var component any
+	if err := json.Unmarshal([]byte(value), &component); err != nil {
+		// older signs may contain plain strings
+		return value
+	}
+	return textComponentText(component)
+}
+
+func textComponentText(component any) string {
+	switch v := component.(type) {
+	case string:
+		return v
+	case []any:
+		var sb strings.Builder
+		for _, child := range v {
+			sb.WriteString(textComponentText(child))
+		}
+		return sb.String()
+	case map[string]any:
+		var sb strings.Builder
+		if text, ok := v["text"].(string); ok {
+			sb.WriteString(text)
+		}
+		if extra, ok := v["extra"]; ok {
+			sb.WriteString(textComponentText(extra))
+		}
+		return sb.String()
+	}
+	return ""
+}
+
+func loadSpawnMarker(worldPath string) (Marker, error) {
+	var level struct {
+		Data struct {
+			SpawnX int32
+			SpawnY int32
+			SpawnZ int32
+		}
+	}
+
+	err := readGzipNBT(filepath.Join(worldPath, "level.dat"), &level)
+	if err != nil {
+		return Marker{}, err
+	}
+
+	return Marker{
+		Type: MarkerSpawn,
+		Name: "Spawn",
+		X:    int(level.Data.SpawnX),
+		Y:    int(level.Data.SpawnY),
+		Z:    int(level.Data.SpawnZ),
+	}, nil
+}
+
+// loadPlayerMarkers returns the last known position of every player within a
+// dimension, names are looked up in the servers usercache.json when present
+func loadPlayerMarkers(worldPath, dimension string) ([]Marker, error) {
+	if _, err := os.Stat(filepath.Join(worldPath, "playerdata")); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(worldPath, "playerdata", "*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	names := loadUserCache(filepath.Join(worldPath, "..", "usercache.json"))
+
+	result := []Marker{}
+	for _, path := range paths {
+		var player struct {
+			Dimension nbt.RawMessage
+			Pos       []float64
+		}
+
+		err := readGzipNBT(path, &player)
+		if err != nil {
+			log.Printf("[markers] failed to read player data %s: %v", path, err)
+			continue
+		}
+
+		if len(player.Pos) != 3 || playerDimension(player.Dimension) != dimension {
+			continue
+		}
+
+		uuid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		name, ok := names[uuid]
+		if !ok {
+			name = uuid
+		}
+
+		result = append(result, Marker{
+			Type: MarkerPlayer,
+			Name: name,
+			X:    int(math.Floor(player.Pos[0])),
+			Y:    int(math.Floor(player.Pos[1])),
+			Z:    int(math.Floor(player.Pos[2])),
+		})
+	}
+	return result, nil
+}
+
+// playerDimension returns the dimension id of a player, versions before 1.16
+// stored the dimension as a number
+func playerDimension(msg nbt.RawMessage) string {
+	var id string
+	if msg.Unmarshal(&id) == nil {
+		return id
+	}
+
+	var legacy int32
+	if msg.Unmarshal(&legacy) == nil {
+		switch legacy {
+		case -1:
+			return DimensionNether
+		case 1:
+			return DimensionEnd
+		}
+	}
+	return DimensionOverworld
+}
+
+func loadUserCache(path string) map[string]string {
+	result := make(map[string]string)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result
+	}
+
+	var users []struct {
+		Name string `json:"name"`
+		UUID string `json:"uuid"`
+	}
+	if err := json.Unmarshal(data, &users); err != nil {
+		log.Printf("[markers] failed to parse %s: %v", path, err)
+		return result
+	}
+
+	for _, user := range users {
+		result[user.UUID] = user.Name
+	}
+	return result
+}
+
+func readGzipNBT(path string, v any) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	r, err := gzip.NewReader(fd)
+	if err != nil {
+		return err
+	}
+
+	_, err = nbt.NewDecoder(r).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return nil
+}
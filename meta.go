@@ -1,19 +1,70 @@
 package carto
 
-import "sync"
+import (
+	"sync"
+
+	"github.com/Tnze/go-mc/save/region"
+)
 
 type RenderMeta struct {
-	RegionTimestamps map[string]int32
+	// ChunkTimestamps holds the modification time of every chunk in each region
+	// as of the last render, indexed by (z * 32) + x
+	ChunkTimestamps map[string][]int32
+
+	// RegionTimestamps is the per-region max timestamp written by older builds,
+	// it is only read to avoid a full re-render after upgrading
+	RegionTimestamps map[string]int32 `json:",omitempty"`
+
+	ZoomLevels int
 }
 
 type WorldRenderOpts struct {
 	Concurrency      int
+	ChunkTimestamps  map[string][]int32
 	RegionTimestamps map[string]int32
+
+	// ZoomLevels is the number of downsampled zoom levels to generate below the region zoom
+	ZoomLevels int
+	// RebuildTiles forces every downsampled tile to be regenerated, not just those with changed regions
+	RebuildTiles bool
+
+	// Clean ignores output left by previous builds, such as legend entries
+	// for biomes which are no longer drawn
+	Clean bool
+
+	// Area limits rendering to the chunks within it, regions and chunks outside
+	// of it are skipped before they are read
+	Area *RenderArea
+}
+
+// previousChunkTimestamps returns the chunk timestamps for a region from the
+// previous render, or nil if the region has never been rendered
+func (o *WorldRenderOpts) previousChunkTimestamps(regionName string, reg *region.Region) []int32 {
+	if timestamps, ok := o.ChunkTimestamps[regionName]; ok && len(timestamps) == 32*32 {
+		return timestamps
+	}
+
+	maxTimestamp, ok := o.RegionTimestamps[regionName]
+	if !ok {
+		return nil
+	}
+
+	// treat every chunk that is not newer than the legacy max timestamp as unchanged
+	timestamps := make([]int32, 32*32)
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			if reg.ExistSector(x, z) && reg.Timestamps[z][x] <= maxTimestamp {
+				timestamps[chunkTimestampIndex(x, z)] = reg.Timestamps[z][x]
+			}
+		}
+	}
+	return timestamps
 }
 
 type WorldRenderResult struct {
 	sync.Mutex
 
-	RenderedChunks   uint32
-	RegionTimestamps map[string]int32
+	RenderedChunks  uint32
+	SkippedChunks   uint32
+	ChunkTimestamps map[string][]int32
 }
@@ -0,0 +1,256 @@
+package carto
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+type ModelInfo struct {
+	Parent   string            `json:"parent"`
+	Textures map[string]string `json:"textures"`
+	Elements []ModelElement    `json:"elements"`
+}
+
+// ModelElement is a cuboid within a model, coordinates are in 1/16ths of a block
+type ModelElement struct {
+	From  [3]float64           `json:"from"`
+	To    [3]float64           `json:"to"`
+	Faces map[string]ModelFace `json:"faces"`
+}
+
+type ModelFace struct {
+	Texture   string      `json:"texture"`
+	UV        *[4]float64 `json:"uv"`
+	TintIndex *int        `json:"tintindex"`
+}
+
+// maxModelDepth limits how far texture references and model parents are
+// followed, guarding against cycles in broken packs
+const maxModelDepth = 32
+
+// modelTopFace is the face of a model which points up once a variants x
+// rotation has been applied, rotating by 90 turns the south face upwards
+var modelTopFace = map[int]string{
+	0:   "up",
+	90:  "south",
+	180: "down",
+	270: "north",
+}
+
+// topFace is an up-facing element face along with the area of the block it covers
+type topFace struct {
+	face ModelFace
+	uv   [4]float64
+
+	// bounds of the element in the x/z plane after rotation and the height of its top
+	minX, minZ, maxX, maxZ int
+	top                    float64
+}
+
+// rotateX rotates an element around the center of the block by a variants x
+// rotation, returning the new from and to coordinates
+func rotateX(from, to [3]float64, rotation int) ([3]float64, [3]float64) {
+	switch rotation {
+	case 90:
+		return [3]float64{from[0], from[2], 16 - to[1]}, [3]float64{to[0], to[2], 16 - from[1]}
+	case 180:
+		return [3]float64{from[0], 16 - to[1], 16 - to[2]}, [3]float64{to[0], 16 - from[1], 16 - from[2]}
+	case 270:
+		return [3]float64{from[0], 16 - to[2], from[1]}, [3]float64{to[0], 16 - from[2], to[1]}
+	}
+	return from, to
+}
+
+// defaultFaceUV returns the UV rectangle used by a face which does not set one
+func defaultFaceUV(direction string, from, to [3]float64) [4]float64 {
+	switch direction {
+	case "down":
+		return [4]float64{from[0], 16 - to[2], to[0], 16 - from[2]}
+	case "north":
+		return [4]float64{16 - to[0], 16 - to[1], 16 - from[0], 16 - from[1]}
+	case "south":
+		return [4]float64{from[0], 16 - to[1], to[0], 16 - from[1]}
+	}
+	return [4]float64{from[0], from[2], to[0], to[2]}
+}
+
+// topFaces returns every face of a model which points up
+func (m *ModelInfo) topFaces(variant BlockStateVariant) []topFace {
+	direction, ok := modelTopFace[((variant.X%360)+360)%360]
+	if !ok {
+		direction = "up"
+	}
+
+	result := []topFace{}
+	for _, element := range m.Elements {
+		face, ok := element.Faces[direction]
+		if !ok {
+			continue
+		}
+
+		uv := defaultFaceUV(direction, element.From, element.To)
+		if face.UV != nil {
+			uv = *face.UV
+		}
+
+		from, to := rotateX(element.From, element.To, variant.X)
+		result = append(result, topFace{
+			face: face,
+			uv:   uv,
+			minX: clampInt(int(from[0]), 0, 16),
+			minZ: clampInt(int(from[2]), 0, 16),
+			maxX: clampInt(int(to[0]+0.999), 0, 16),
+			maxZ: clampInt(int(to[2]+0.999), 0, 16),
+			top:  to[1],
+		})
+	}
+	return result
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+	return v
+}
+
+// textureRegion converts a UV rectangle into pixel bounds within a texture,
+// animated textures are sampled from their first frame
+func textureRegion(texture image.Image, uv [4]float64) image.Rectangle {
+	bounds := texture.Bounds()
+	size := bounds.Dx()
+	scale := float64(size) / 16
+
+	x0, x1 := uv[0], uv[2]
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	y0, y1 := uv[1], uv[3]
+	if y0 > y1 {
+		y0, y1 = y1, y0
+	}
+
+	rect := image.Rect(int(x0*scale), int(y0*scale), int(x1*scale+0.999), int(y1*scale+0.999))
+	if rect.Dx() == 0 {
+		rect.Max.X = rect.Min.X + 1
+	}
+	if rect.Dy() == 0 {
+		rect.Max.Y = rect.Min.Y + 1
+	}
+	return rect.Add(bounds.Min).Intersect(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+size, bounds.Min.Y+size))
+}
+
+// colorSum accumulates alpha weighted texture colors
+type colorSum struct {
+	r, g, b, a, count float64
+}
+
+func (c *colorSum) addTexture(texture image.Image, rect image.Rectangle, weight float64) {
+	for i := rect.Min.X; i < rect.Max.X; i++ {
+		for j := rect.Min.Y; j < rect.Max.Y; j++ {
+			rrr, ggg, bbb, aaa := texture.At(i, j).RGBA()
+			w := weight / float64(rect.Dx()*rect.Dy())
+			c.r += float64(rrr) * float64(aaa) * w
+			c.g += float64(ggg) * float64(aaa) * w
+			c.b += float64(bbb) * float64(aaa) * w
+			c.a += float64(aaa) * w
+			c.count += w
+		}
+	}
+}
+
+func (c *colorSum) color() color.Color {
+	if c.a == 0 || c.count == 0 {
+		return &color.RGBA64{}
+	}
+
+	return &color.RGBA64{
+		R: uint16(c.r / c.a),
+		G: uint16(c.g / c.a),
+		B: uint16(c.b / c.a),
+		A: uint16(c.a / c.count),
+	}
+}
+
+// faceTexture returns the namespaced texture drawn on a face
+func (m *ModelInfo) faceTexture(face ModelFace) (string, bool) {
+	textureName, ok := m.resolveTexture(strings.TrimPrefix(face.Texture, "#"))
+	if !ok && !strings.HasPrefix(face.Texture, "#") {
+		return namespacedName(face.Texture), true
+	}
+	return textureName, ok
+}
+
+// sideTinted reports whether any side face of the model drawing a texture is
+// tinted, faces drawing an overlay over it are not counted
+func (m *ModelInfo) sideTinted(texture string) bool {
+	for _, element := range m.Elements {
+		for _, direction := range []string{"north", "south", "east", "west"} {
+			face, ok := element.Faces[direction]
+			if !ok || face.TintIndex == nil {
+				continue
+			}
+
+			if name, ok := m.faceTexture(face); ok && name == texture {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasTint reports whether any face of the model is tinted
+func (m *ModelInfo) hasTint() bool {
+	for _, element := range m.Elements {
+		for _, face := range element.Faces {
+			if face.TintIndex != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveTexture follows #references within a models textures, returning the
+// namespaced texture name
+func (m *ModelInfo) resolveTexture(key string) (string, bool) {
+	value, ok := m.Textures[key]
+	for depth := 0; ok && strings.HasPrefix(value, "#"); depth++ {
+		if depth == maxModelDepth {
+			return "", false
+		}
+		value, ok = m.Textures[strings.TrimPrefix(value, "#")]
+	}
+
+	if !ok || value == "" {
+		return "", false
+	}
+	return namespacedName(value), true
+}
+
+// firstTexture returns the first of the given texture keys which resolves,
+// falling back to any texture in the model
+func (m *ModelInfo) firstTexture(keys ...string) (string, bool) {
+	for _, key := range keys {
+		if texture, ok := m.resolveTexture(key); ok {
+			return texture, true
+		}
+	}
+
+	all := make([]string, 0, len(m.Textures))
+	for k := range m.Textures {
+		all = append(all, k)
+	}
+	sort.Strings(all)
+
+	for _, key := range all {
+		if texture, ok := m.resolveTexture(key); ok {
+			return texture, true
+		}
+	}
+	return "", false
+}
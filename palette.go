@@ -6,10 +6,10 @@ import (
 	"image"
 	"image/color"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 
-	"github.com/Tnze/go-mc/nbt"
 	"github.com/Tnze/go-mc/save"
 )
 
@@ -28,60 +28,36 @@ func isAirBlock(block string) bool {
 	return ok
 }
 
-var grassBlocks = map[string]struct{}{
-	"minecraft:grass":       {},
-	"minecraft:grass_block": {},
-	"minecraft:tall_grass":  {},
-	"minecraft:vine":        {},
-	"minecraft:fern":        {},
-	"minecraft:large_fern":  {},
-}
-
-func isGrassBlock(block string) bool {
-	_, ok := grassBlocks[block]
-	return ok
-}
-
+// foliageBlocks are tinted by the foliage color of the biome
 var foliageBlocks = map[string]struct{}{
-	"minecraft:oak_leaves":      {},
-	"minecraft:jungle_leaves":   {},
-	"minecraft:acacia_leaves":   {},
-	"minecraft:dark_oak_leaves": {},
-	"minecraft:mangrove_leaves": {},
-	"minecraft:azalea_leaves":   {},
-	"minecraft:cherry_leaves":   {},
+	"minecraft:vine": {},
 }
 
 func isFoliageBlock(block string) bool {
-	_, ok := foliageBlocks[block]
-	return ok
-}
-
-type BlockStateMultipart struct {
-	Apply json.RawMessage `json:"apply"`
-	When  json.RawMessage `json:"when"`
-}
-
-type BlockStateMultipartApply struct {
-	Model string `json:"model"`
-}
-
-type BlockStateMultipartWhen map[string]string
-type BlockStateMultipartWhenOr struct {
-	Or []BlockStateMultipartWhen `json:"OR"`
-}
-
-type BlockStateVariant struct {
-	Model string `json:"model"`
+	if _, ok := foliageBlocks[block]; ok {
+		return true
+	}
+	return strings.HasSuffix(block, "_leaves")
 }
 
-type BlockStateInfo struct {
-	Variants  map[string]json.RawMessage `json:"variants"`
-	Multipart []BlockStateMultipart      `json:"multipart"`
+// fixedTints are blocks tinted with the same color in every biome, blocks
+// tinted by their state use the color of a typical one
+var fixedTints = map[string]color.RGBA{
+	"minecraft:birch_leaves":          {R: 0x80, G: 0xa7, B: 0x55, A: 255},
+	"minecraft:spruce_leaves":         {R: 0x61, G: 0x99, B: 0x61, A: 255},
+	"minecraft:mangrove_leaves":       {R: 0x92, G: 0xc6, B: 0x48, A: 255},
+	"minecraft:lily_pad":              {R: 0x20, G: 0x80, B: 0x30, A: 255},
+	"minecraft:redstone_wire":         {R: 0x4c, G: 0x00, B: 0x00, A: 255},
+	"minecraft:pumpkin_stem":          {R: 0x80, G: 0xdf, B: 0x10, A: 255},
+	"minecraft:melon_stem":            {R: 0x80, G: 0xdf, B: 0x10, A: 255},
+	"minecraft:attached_pumpkin_stem": {R: 0xe0, G: 0xc7, B: 0x1c, A: 255},
+	"minecraft:attached_melon_stem":   {R: 0xe0, G: 0xc7, B: 0x1c, A: 255},
 }
 
-type ModelInfo struct {
-	Textures map[string]string `json:"textures"`
+// isWaterBlock reports whether a block is drawn as water, fluids have no
+// model so they are always tinted
+func isWaterBlock(block string) bool {
+	return block == "minecraft:water" || block == "minecraft:bubble_column"
 }
 
 type Palette struct {
@@ -96,33 +72,91 @@ type Palette struct {
 	blockStateCache map[string]BlockStateInfo
 	textureCache    map[string]image.Image
 
-	blockStateTextures map[string]string
-	blockStateColors   map[string]color.Color
+	blockStateTextures     map[string]string
+	blockStateSideTextures map[string]string
+	blockStateColors       map[string]color.Color
+	blockStateSideColors   map[string]color.Color
+
+	// blockStateTinted is set for blocks whose visible top is tinted by the
+	// biome, blockStateSideTinted for those whose sides are
+	blockStateTinted     map[string]bool
+	blockStateSideTinted map[string]bool
 
 	grassColorMap   image.Image
 	foliageColorMap image.Image
+
+	// placeholder is returned for block states which could not be resolved,
+	// when nil those blocks are skipped
+	placeholder color.Color
+
+	missingLock        sync.Mutex
+	missingBlockStates map[string]struct{}
+	missingBiomes      map[string]struct{}
 }
 
-func NewPalette(loader *AssetLoader) *Palette {
+// DefaultPlaceholderColor is drawn in place of blocks missing from the assets
+var DefaultPlaceholderColor = color.RGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff}
+
+// defaultBiome is used to tint blocks in biomes missing from the assets, it
+// matches plains
+var defaultBiome = Biome{Temperature: 0.8, Downfall: 0.4}
+
+func NewPalette(loader *AssetLoader) (*Palette, error) {
+	// a precomputed palette carries its own biome colors so the colormaps are optional
 	grassColorMap, err := loader.LoadPNG("assets/minecraft/textures/colormap/grass.png")
-	if err != nil {
-		log.Panicf("Failed to load grass colormap: %v", err)
+	if err != nil && loader.Precomputed == nil {
+		return nil, fmt.Errorf("failed to load grass colormap: %v", err)
 	}
 	foliageColorMap, err := loader.LoadPNG("assets/minecraft/textures/colormap/foliage.png")
-	if err != nil {
-		log.Panicf("Failed to load foliage colormap: %v", err)
+	if err != nil && loader.Precomputed == nil {
+		return nil, fmt.Errorf("failed to load foliage colormap: %v", err)
 	}
 	return &Palette{
-		loader:             loader,
-		biomeCache:         make(map[save.BiomeState]*Biome),
-		modelCache:         make(map[string]ModelInfo),
-		blockStateCache:    make(map[string]BlockStateInfo),
-		textureCache:       make(map[string]image.Image),
-		blockStateTextures: make(map[string]string),
-		blockStateColors:   make(map[string]color.Color),
-		grassColorMap:      grassColorMap,
-		foliageColorMap:    foliageColorMap,
+		loader:                 loader,
+		biomeCache:             make(map[save.BiomeState]*Biome),
+		modelCache:             make(map[string]ModelInfo),
+		blockStateCache:        make(map[string]BlockStateInfo),
+		textureCache:           make(map[string]image.Image),
+		blockStateTextures:     make(map[string]string),
+		blockStateSideTextures: make(map[string]string),
+		blockStateColors:       make(map[string]color.Color),
+		blockStateSideColors:   make(map[string]color.Color),
+		blockStateTinted:       make(map[string]bool),
+		blockStateSideTinted:   make(map[string]bool),
+		grassColorMap:          grassColorMap,
+		foliageColorMap:        foliageColorMap,
+		placeholder:            DefaultPlaceholderColor,
+		missingBlockStates:     make(map[string]struct{}),
+		missingBiomes:          make(map[string]struct{}),
+	}, nil
+}
+
+// blockStateString formats a block state for reports, e.g. minecraft:oak_log{axis:"y"}
+func blockStateString(state save.BlockState) string {
+	return state.Name + state.Properties.String()
+}
+
+// GetMissingBlockStates returns every block state which could not be resolved
+func (p *Palette) GetMissingBlockStates() []string {
+	p.missingLock.Lock()
+	defer p.missingLock.Unlock()
+	return sortedKeys(p.missingBlockStates)
+}
+
+// GetMissingBiomes returns every biome which could not be loaded
+func (p *Palette) GetMissingBiomes() []string {
+	p.missingLock.Lock()
+	defer p.missingLock.Unlock()
+	return sortedKeys(p.missingBiomes)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
 	}
+	sort.Strings(result)
+	return result
 }
 
 func (p *Palette) Prepare(section save.Section) {
@@ -139,59 +173,132 @@ func (p *Palette) Prepare(section save.Section) {
 		if isAirBlock(state.Name) {
 			continue
 		}
-		p.prepareBlockState(state)
+
+		err := p.prepareBlockState(state)
+		if err != nil {
+			// remember the failure so the block is only reported once
+			log.Printf("[palette] %v", err)
+			p.blockStateColors[stateStr] = nil
+			p.blockStateSideColors[stateStr] = nil
+
+			p.missingLock.Lock()
+			p.missingBlockStates[blockStateString(state)] = struct{}{}
+			p.missingLock.Unlock()
+		}
 	}
 }
 
-func (p *Palette) getBiome(state save.BiomeState) *Biome {
+func (p *Palette) getBiome(state save.BiomeState) (*Biome, error) {
 	p.biomeLock.RLock()
 	if res, ok := p.biomeCache[state]; ok {
 		p.biomeLock.RUnlock()
-		return res
+		return res, nil
 	}
 	p.biomeLock.RUnlock()
 
 	p.biomeLock.Lock()
 	defer p.biomeLock.Unlock()
 
-	path := fmt.Sprintf("data/minecraft/worldgen/biome/%s.json", strings.Split(string(state), ":")[1])
+	path := assetPath("data", "worldgen/biome", string(state), "json")
 	data, err := p.loader.LoadRaw(path)
 	if err != nil {
-		log.Panicf("failed to load biome %s: %s", state, err)
+		return nil, fmt.Errorf("failed to load biome %s: %v", state, err)
 	}
 
 	var biome Biome
 	err = json.Unmarshal(data, &biome)
 	if err != nil {
-		log.Panicf("failed to unmarshal biome %s: %s", state, err)
+		return nil, fmt.Errorf("failed to unmarshal biome %s: %v", state, err)
 	}
 
 	p.biomeCache[state] = &biome
-	return &biome
+	return &biome, nil
+}
+
+// getBiomeOrDefault returns a biome, falling back to plains and recording the
+// biome as missing if it cannot be loaded
+func (p *Palette) getBiomeOrDefault(state save.BiomeState) *Biome {
+	biome, err := p.getBiome(state)
+	if err == nil {
+		return biome
+	}
+
+	p.biomeLock.Lock()
+	p.biomeCache[state] = &defaultBiome
+	p.biomeLock.Unlock()
+
+	log.Printf("[palette] %v", err)
+	p.missingLock.Lock()
+	p.missingBiomes[string(state)] = struct{}{}
+	p.missingLock.Unlock()
+	return &defaultBiome
+}
+
+// GetFaceTextures returns the textures drawn on the top and sides of a block
+// state, they are nil for blocks whose colors do not come from the assets
+func (p *Palette) GetFaceTextures(state save.BlockState) (image.Image, image.Image) {
+	p.RLock()
+	defer p.RUnlock()
+	stateStr := state.Name + "/" + state.Properties.String()
+	return p.textureCache[p.blockStateTextures[stateStr]], p.textureCache[p.blockStateSideTextures[stateStr]]
 }
 
-func (p *Palette) GetTexture(state save.BlockState) image.Image {
+// GetTints returns the colors the top and side textures of a block are
+// multiplied with in a biome, nil for faces which are not tinted
+func (p *Palette) GetTints(state save.BlockState, biome save.BiomeState) (color.Color, color.Color) {
 	p.RLock()
 	defer p.RUnlock()
 	stateStr := state.Name + "/" + state.Properties.String()
-	texName, ok := p.blockStateTextures[stateStr]
+
+	if p.blockStateColors[stateStr] == nil {
+		return nil, nil
+	}
+
+	var top, side color.Color
+	if p.blockStateTinted[stateStr] {
+		top = p.fixColor(state, nil, biome)
+	}
+	if p.blockStateSideTinted[stateStr] {
+		side = p.fixColor(state, nil, biome)
+	}
+	return top, side
+}
+
+func (p *Palette) GetColor(state save.BlockState, biome save.BiomeState) color.Color {
+	p.RLock()
+	defer p.RUnlock()
+	stateStr := state.Name + "/" + state.Properties.String()
+
+	color, ok := p.blockStateColors[stateStr]
 	if !ok {
 		return nil
+	} else if color == nil {
+		return p.placeholder
+	} else if !p.blockStateTinted[stateStr] {
+		return color
 	}
-	return p.textureCache[texName]
+	return p.fixColor(state, color, biome)
 }
 
-func (p *Palette) GetColor(state save.BlockState, biome save.BiomeState) color.Color {
+// GetSideColor returns the color of the sides of a block, for blocks without a
+// distinct side texture this is the same as GetColor
+func (p *Palette) GetSideColor(state save.BlockState, biome save.BiomeState) color.Color {
 	p.RLock()
 	defer p.RUnlock()
 	stateStr := state.Name + "/" + state.Properties.String()
 
-	color := p.blockStateColors[stateStr]
+	color, ok := p.blockStateSideColors[stateStr]
+	if !ok {
+		return nil
+	} else if color == nil {
+		return p.placeholder
+	}
 
-	if color != nil {
-		return p.fixColor(state, color, biome)
+	// grass blocks have a dirt side which is not tinted
+	if !p.blockStateSideTinted[stateStr] {
+		return color
 	}
-	return color
+	return p.fixColor(state, color, biome)
 }
 
 func clamp(v, min, max float64) float64 {
@@ -204,285 +311,389 @@ func clamp(v, min, max float64) float64 {
 	}
 }
 
-func (p *Palette) fixColor(state save.BlockState, clr color.Color, biome save.BiomeState) color.Color {
-	if isGrassBlock(state.Name) {
-		x, y := p.getBiome(biome).ColorMapCoords()
-		return p.grassColorMap.At(x, y)
-	} else if isFoliageBlock(state.Name) {
-		x, y := p.getBiome(biome).ColorMapCoords()
-		return p.foliageColorMap.At(x, y)
-	} else if state.Name == "minecraft:birch_leaves" {
-		return color.RGBA{
-			R: 0x80,
-			G: 0xa7,
-			B: 0x55,
-			A: 255,
-		}
-	} else if state.Name == "minecraft:spruce_leaves" {
-		return color.RGBA{
-			R: 0x61,
-			G: 0x99,
-			B: 0x61,
-			A: 255,
-		}
-	} else if state.Name == "minecraft:water" {
-		switch biome {
-		case "minecraft:swamp":
-			return color.RGBA{R: 0x61, G: 0x7B, B: 0x64, A: 255}
-		case "minecraft:river":
-			return color.RGBA{R: 0x3F, G: 0x76, B: 0xE4, A: 255}
-		case "minecraft:ocean":
-			return color.RGBA{R: 0x3F, G: 0x76, B: 0xE4, A: 255}
-		case "minecraft:lukewarm_ocean":
-			return color.RGBA{R: 0x45, G: 0xAD, B: 0xF2, A: 255}
-		case "minecraft:warm_ocean":
-			return color.RGBA{R: 0x43, G: 0xD5, B: 0xEE, A: 255}
-		case "minecraft:cold_ocean":
-			return color.RGBA{R: 0x3D, G: 0x57, B: 0xD6, A: 255}
-		case "minecraft:frozen_river":
-			return color.RGBA{R: 0x39, G: 0x38, B: 0xC9, A: 255}
-		case "minecraft:frozen_ocean":
-			return color.RGBA{R: 0x39, G: 0x38, B: 0xC9, A: 255}
-		default:
-			return color.RGBA{R: 0x3f, G: 0x76, B: 0xe4, A: 255}
-		}
+// tintKind is the biome color a tinted block takes on
+type tintKind uint8
+
+const (
+	tintNone tintKind = iota
+	tintGrass
+	tintFoliage
+	tintWater
+	tintFixed
+)
+
+// blockTintKind returns which biome color a block tinted by its model takes
+// on, the game decides this per block so those without a known source use the
+// grass color
+func blockTintKind(block string) tintKind {
+	if isWaterBlock(block) {
+		return tintWater
+	} else if _, ok := fixedTints[block]; ok {
+		return tintFixed
+	} else if isFoliageBlock(block) {
+		return tintFoliage
 	}
-	return clr
+	return tintGrass
 }
 
-func (p *Palette) prepareBlockState(state save.BlockState) {
-	blockStateInfo, ok := p.blockStateCache[state.Name]
-	if !ok {
-		rawName := strings.Split(state.Name, ":")[1]
-		file, ok := p.loader.Files[fmt.Sprintf("assets/minecraft/blockstates/%s.json", rawName)]
-		if !ok {
-			log.Panicf("does not exist: %v", rawName)
-		}
+// getTintKind returns how the top of a block state is tinted, tintNone for
+// blocks whose color does not depend on the biome
+func (p *Palette) getTintKind(state save.BlockState) tintKind {
+	p.RLock()
+	defer p.RUnlock()
+	stateStr := state.Name + "/" + state.Properties.String()
 
-		fd, err := file.Open()
-		if err != nil {
-			log.Panicf("failed to open file %s: %v", rawName, err)
-		}
-		defer fd.Close()
+	if !p.blockStateTinted[stateStr] || p.blockStateColors[stateStr] == nil {
+		return tintNone
+	}
+	return blockTintKind(state.Name)
+}
 
-		err = json.NewDecoder(fd).Decode(&blockStateInfo)
-		p.blockStateCache[state.Name] = blockStateInfo
-		if err != nil {
-			log.Panicf("failed to decode json file %s: %v", rawName, err)
-		}
+// tintColor returns the color of a biome dependent tint
+func (p *Palette) tintColor(kind tintKind, biome save.BiomeState) color.Color {
+	switch kind {
+	case tintGrass:
+		return p.grassColor(biome)
+	case tintFoliage:
+		return p.foliageColor(biome)
+	case tintWater:
+		return p.waterColor(biome)
+	}
+	return nil
+}
+
+// fixColor replaces the color of a tinted block with its tint in the biome
+func (p *Palette) fixColor(state save.BlockState, clr color.Color, biome save.BiomeState) color.Color {
+	var tint color.Color
+	if kind := blockTintKind(state.Name); kind == tintFixed {
+		tint = fixedTints[state.Name]
+	} else {
+		tint = p.tintColor(kind, biome)
 	}
 
-	propsMap := makeStatePropertiesMap(state.Properties)
+	if tint == nil {
+		return clr
+	}
+	return tint
+}
 
-	var modelName string
+func (p *Palette) grassColor(biome save.BiomeState) color.Color {
+	if clr, ok := p.loader.Precomputed.biomeColor(biome, "grass"); ok {
+		return clr
+	}
 
-	if blockStateInfo.Multipart != nil {
-		modelName = findMultipartModel(propsMap, blockStateInfo.Multipart)
-	} else if len(blockStateInfo.Variants) == 1 {
-		// TODO: does indexing this even matter?
-		modelName = decodeVariants(firstVariant(blockStateInfo.Variants))[0].Model
+	b := p.getBiomeOrDefault(biome)
+	var clr color.Color
+	if b.Effects.GrassColor != nil {
+		clr = rgbColor(*b.Effects.GrassColor)
+	} else if p.grassColorMap != nil {
+		x, y := b.ColorMapCoords()
+		clr = p.grassColorMap.At(x, y)
 	} else {
-		modelName = findVariants(makeStatePropertiesMap(state.Properties), blockStateInfo.Variants)[0].Model
+		return nil
 	}
+	return b.Effects.modifyGrassColor(clr)
+}
 
-	modelInfo, ok := p.modelCache[modelName]
-	if !ok {
-		rawName := strings.Split(modelName, ":")[1]
-		file, ok := p.loader.Files[fmt.Sprintf("assets/minecraft/models/%s.json", rawName)]
-		if !ok {
-			log.Panicf("does not exist: %v (%v)", rawName, modelName)
-		}
-
-		fd, err := file.Open()
-		if err != nil {
-			log.Panicf("failed to open file %s: %v", rawName, err)
-		}
-		defer fd.Close()
+func (p *Palette) foliageColor(biome save.BiomeState) color.Color {
+	if clr, ok := p.loader.Precomputed.biomeColor(biome, "foliage"); ok {
+		return clr
+	}
 
-		err = json.NewDecoder(fd).Decode(&modelInfo)
-		p.modelCache[state.Name] = modelInfo
-		if err != nil {
-			log.Panicf("failed to decode json file %s: %v", rawName, err)
-		}
+	b := p.getBiomeOrDefault(biome)
+	if b.Effects.FoliageColor != nil {
+		return rgbColor(*b.Effects.FoliageColor)
+	} else if p.foliageColorMap == nil {
+		return nil
+	}
+	x, y := b.ColorMapCoords()
+	return p.foliageColorMap.At(x, y)
+}
 
+func (p *Palette) waterColor(biome save.BiomeState) color.Color {
+	if clr, ok := p.loader.Precomputed.biomeColor(biome, "water"); ok {
+		return clr
 	}
 
-	var textureName string
-	if len(modelInfo.Textures) == 1 {
-		for _, v := range modelInfo.Textures {
-			textureName = v
-		}
-	} else if top, ok := modelInfo.Textures["top"]; ok {
-		textureName = top
-	} else if all, ok := modelInfo.Textures["all"]; ok {
-		textureName = all
-	} else if all, ok := modelInfo.Textures["texture"]; ok {
-		textureName = all
-	} else {
-		// TODO: random
-		for _, v := range modelInfo.Textures {
-			textureName = v
-			break
-		}
+	b := p.getBiomeOrDefault(biome)
+	if b.Effects.WaterColor != nil {
+		return rgbColor(*b.Effects.WaterColor)
 	}
-	if textureName == "" || textureName == "#texture" {
-		log.Panicf("failed to load for %v / %v", state.Name, modelName)
+	return defaultWaterColor
+}
+
+// resolvedBlockState is the appearance of a single block state
+type resolvedBlockState struct {
+	texture     string
+	sideTexture string
+	top         color.Color
+	side        color.Color
+
+	// tinted is set when the visible top of the block is tinted by the biome
+	// and sideTinted when its sides are
+	tinted     bool
+	sideTinted bool
+}
+
+func (p *Palette) prepareBlockState(state save.BlockState) error {
+	propsMap, err := makeStatePropertiesMap(state.Properties)
+	if err != nil {
+		return fmt.Errorf("block %s: invalid properties: %v", state.Name, err)
 	}
 
-	if strings.Contains(textureName, ":") {
-		textureName = strings.Split(textureName, ":")[1]
+	resolved, err := p.resolveBlockState(state.Name, propsMap)
+	if err != nil {
+		return err
 	}
 
 	stateStr := state.Name + "/" + state.Properties.String()
-	p.blockStateTextures[stateStr] = textureName
+	p.blockStateTextures[stateStr] = resolved.texture
+	p.blockStateSideTextures[stateStr] = resolved.sideTexture
+	p.blockStateColors[stateStr] = resolved.top
+	p.blockStateSideColors[stateStr] = resolved.side
+	p.blockStateTinted[stateStr] = resolved.tinted
+	p.blockStateSideTinted[stateStr] = resolved.sideTinted
+	return nil
+}
 
-	texture, ok := p.textureCache[textureName]
+// resolveBlockState finds the colors of a block state, user overrides are
+// checked first, then the precomputed palette and finally the assets
+func (p *Palette) resolveBlockState(name string, properties map[string]string) (resolvedBlockState, error) {
+	if clr, ok := matchColorOverride(p.loader.ColorOverrides, name, properties); ok {
+		return resolvedBlockState{top: clr, side: clr}, nil
+	}
+
+	if p.loader.Precomputed != nil {
+		if block, ok := p.loader.Precomputed.findBlock(name, properties); ok {
+			return block, nil
+		}
+	}
+
+	blockStateInfo, ok := p.blockStateCache[name]
 	if !ok {
-		image, err := p.loader.LoadPNG(fmt.Sprintf("assets/minecraft/textures/%s.png", textureName))
+		data, err := p.loader.LoadRaw(assetPath("assets", "blockstates", name, "json"))
 		if err != nil {
-			log.Panicf("failed to load texture image %s: %v", textureName, err)
+			return resolvedBlockState{}, fmt.Errorf("block %s: %v", name, err)
 		}
 
-		texture = image
-		p.textureCache[textureName] = image
+		err = json.Unmarshal(data, &blockStateInfo)
+		if err != nil {
+			return resolvedBlockState{}, fmt.Errorf("block %s: failed to decode blockstate: %v", name, err)
+		}
+		p.blockStateCache[name] = blockStateInfo
 	}
 
-	p.blockStateColors[stateStr] = generateBlockStateColor(texture)
-}
+	variants, err := blockStateInfo.Resolve(properties)
+	if err != nil {
+		return resolvedBlockState{}, fmt.Errorf("block %s: %v", name, err)
+	}
 
-func generateBlockStateColor(texture image.Image) color.Color {
-	bounds := texture.Bounds()
-	var rr, gg, bb, aa, count float64
-	for i := bounds.Min.X; i < bounds.Max.X; i++ {
-		for j := bounds.Min.Y; j < bounds.Max.Y; j++ {
-			col := texture.At(i, j)
-			rrr, ggg, bbb, aaa := col.RGBA()
-			rr += float64(rrr) * float64(aaa)
-			gg += float64(ggg) * float64(aaa)
-			bb += float64(bbb) * float64(aaa)
-			aa += float64(aaa)
-			count++
-		}
+	// multipart blocks draw several models, the first is usually the main body
+	modelName := namespacedName(variants[0].Model)
+	modelInfo, err := p.loadModel(modelName)
+	if err != nil {
+		return resolvedBlockState{}, fmt.Errorf("block %s: %v", name, err)
 	}
-	return &color.RGBA64{
-		R: uint16(rr / aa),
-		G: uint16(gg / aa),
-		B: uint16(bb / aa),
-		A: uint16(aa / count),
+
+	textureName, ok := modelInfo.firstTexture("top", "end", "up", "all", "texture")
+	if !ok {
+		return resolvedBlockState{}, fmt.Errorf("block %s: no texture found in model %s", name, modelName)
 	}
-}
 
-func makeStatePropertiesMap(msg nbt.RawMessage) map[string]string {
-	test := map[string]string{}
-	if msg.Type == nbt.TagEnd {
-		return test
+	texture, err := p.loadTexture(textureName)
+	if err != nil {
+		return resolvedBlockState{}, fmt.Errorf("block %s: %v", name, err)
 	}
 
-	err := msg.Unmarshal(&test)
+	topColor, tinted, err := p.sampleTopColor(variants)
 	if err != nil {
-		panic(err)
+		return resolvedBlockState{}, fmt.Errorf("block %s: %v", name, err)
 	}
-	return test
-}
 
-func firstVariant(variants map[string]json.RawMessage) json.RawMessage {
-	for _, v := range variants {
-		return v
+	// blocks without any up facing elements, like flowers, use their whole texture
+	if topColor == nil {
+		topColor = generateBlockStateColor(texture)
+		tinted = p.variantsTinted(variants)
 	}
-	return nil
-}
 
-func decodeVariants(raw json.RawMessage) []BlockStateVariant {
-	var variants []BlockStateVariant
-	err := json.Unmarshal(raw, &variants)
-	if err == nil {
-		return variants
-	} else {
-		var v BlockStateVariant
-		err = json.Unmarshal(raw, &v)
-		if err == nil {
-			variants = append(variants, v)
-			return variants
+	sideTextureName, sideTexture := textureName, texture
+	if side, ok := modelInfo.firstTexture("side", "north", "all"); ok && side != textureName {
+		sideTexture, err = p.loadTexture(side)
+		if err != nil {
+			return resolvedBlockState{}, fmt.Errorf("block %s: %v", name, err)
 		}
+		sideTextureName = side
 	}
-	return nil
+	sideTinted := modelInfo.sideTinted(sideTextureName)
+
+	if isWaterBlock(name) {
+		tinted, sideTinted = true, true
+	}
+
+	return resolvedBlockState{
+		texture:     textureName,
+		sideTexture: sideTextureName,
+		top:         topColor,
+		side:        generateBlockStateColor(sideTexture),
+		tinted:      tinted,
+		sideTinted:  sideTinted,
+	}, nil
 }
 
-func parseVariantProperties(raw string) map[string]string {
-	result := make(map[string]string)
-	parts := strings.Split(raw, ",")
-	for _, part := range parts {
-		partSplit := strings.Split(part, "=")
-		result[partSplit[0]] = partSplit[1]
+// variantsTinted reports whether any face of the models drawn for a block state is tinted
+func (p *Palette) variantsTinted(variants []BlockStateVariant) bool {
+	for _, variant := range variants {
+		model, err := p.loadModel(namespacedName(variant.Model))
+		if err == nil && model.hasTint() {
+			return true
+		}
 	}
-	return result
+	return false
 }
 
-func findVariants(properties map[string]string, raw map[string]json.RawMessage) []BlockStateVariant {
-	for k, v := range raw {
-		p := parseVariantProperties(k)
+// sampleTopColor averages the textures of every face visible from above,
+// weighted by how much of the block each face covers. Returns a nil color if
+// the models have no up facing faces.
+func (p *Palette) sampleTopColor(variants []BlockStateVariant) (color.Color, bool, error) {
+	faces := []topFace{}
+	models := []ModelInfo{}
+	for _, variant := range variants {
+		model, err := p.loadModel(namespacedName(variant.Model))
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, face := range model.topFaces(variant) {
+			faces = append(faces, face)
+			models = append(models, model)
+		}
+	}
 
-		matches := true
-		for k, v := range p {
-			if properties[k] != v {
-				matches = false
-				continue
+	// faces from every model are layered together, highest first
+	order := make([]int, len(faces))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return faces[order[i]].top > faces[order[j]].top
+	})
+
+	var covered [16][16]bool
+	var sum colorSum
+	var coveredCells, tintedCells int
+	for _, idx := range order {
+		face := faces[idx]
+
+		cells := 0
+		for x := face.minX; x < face.maxX; x++ {
+			for z := face.minZ; z < face.maxZ; z++ {
+				if !covered[x][z] {
+					covered[x][z] = true
+					cells++
+				}
 			}
 		}
-		if !matches {
+		if cells == 0 {
+			continue
+		}
+
+		textureName, ok := models[idx].faceTexture(face.face)
+		if !ok {
 			continue
 		}
 
-		return decodeVariants(v)
+		texture, err := p.loadTexture(textureName)
+		if err != nil {
+			return nil, false, err
+		}
+
+		sum.addTexture(texture, textureRegion(texture, face.uv), float64(cells))
+		coveredCells += cells
+		if face.face.TintIndex != nil {
+			tintedCells += cells
+		}
 	}
 
-	return nil
+	if coveredCells == 0 {
+		return nil, false, nil
+	}
+	return sum.color(), tintedCells*2 >= coveredCells, nil
 }
 
-func decodeMultipart(raw BlockStateMultipart) ([]BlockStateMultipartApply, []BlockStateMultipartWhen) {
-	applies := []BlockStateMultipartApply{}
-	whens := []BlockStateMultipartWhen{}
+// loadModel loads a model by its namespaced name, merging in the textures of
+// all of its parents
+func (p *Palette) loadModel(name string) (ModelInfo, error) {
+	if model, ok := p.modelCache[name]; ok {
+		return model, nil
+	}
 
-	var apply BlockStateMultipartApply
-	err := json.Unmarshal(raw.Apply, &apply)
-	if err == nil {
-		applies = append(applies, apply)
-	} else {
-		err = json.Unmarshal(raw.Apply, &applies)
+	model := ModelInfo{Textures: make(map[string]string)}
+	chain := []string{}
+	for current := name; current != ""; {
+		if len(chain) == maxModelDepth {
+			return model, fmt.Errorf("model %s: too many parents", name)
+		}
+		chain = append(chain, current)
+
+		// builtin models such as builtin/generated have no file
+		if strings.HasPrefix(strings.TrimPrefix(current, "minecraft:"), "builtin/") {
+			break
+		}
+
+		data, err := p.loader.LoadRaw(assetPath("assets", "models", current, "json"))
 		if err != nil {
-			log.Panicf("FAILED APPLY: %v", string(raw.Apply))
+			return model, err
 		}
-	}
 
-	if len(raw.When) > 0 {
-		var when BlockStateMultipartWhen
-		err = json.Unmarshal(raw.When, &when)
-		if err == nil {
-			whens = append(whens, when)
-		} else {
-			err = json.Unmarshal(raw.When, &whens)
-			if err != nil {
-
-				var whenOr BlockStateMultipartWhenOr
-				err = json.Unmarshal(raw.When, &whenOr)
-				if err == nil {
-					whens = append(whens, whenOr.Or...)
-				} else {
-					log.Panicf("FAILED WHEN: %v / %v", string(raw.When), string(raw.Apply))
-				}
+		var info ModelInfo
+		err = json.Unmarshal(data, &info)
+		if err != nil {
+			return model, fmt.Errorf("failed to decode model %s: %v", current, err)
+		}
+
+		// textures from children override those of their parents
+		for k, v := range info.Textures {
+			if _, ok := model.Textures[k]; !ok {
+				model.Textures[k] = v
 			}
 		}
+
+		if model.Parent == "" {
+			model.Parent = info.Parent
+		}
+
+		// elements are not merged, the closest model which defines them wins
+		if model.Elements == nil {
+			model.Elements = info.Elements
+		}
+
+		current = ""
+		if info.Parent != "" {
+			current = namespacedName(info.Parent)
+		}
 	}
 
-	return applies, whens
+	p.modelCache[name] = model
+	return model, nil
 }
 
-func findMultipartModel(properties map[string]string, raw []BlockStateMultipart) string {
-	for _, rawmp := range raw {
-		applies, _ := decodeMultipart(rawmp)
-		// no clue if this is actually valid, how do we interact with the whens array?
-		return applies[0].Model
+// loadTexture loads a block texture by its namespaced name
+func (p *Palette) loadTexture(textureName string) (image.Image, error) {
+	texture, ok := p.textureCache[textureName]
+	if !ok {
+		image, err := p.loader.LoadPNG(assetPath("assets", "textures", textureName, "png"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load texture image %s: %v", textureName, err)
+		}
 
+		texture = image
+		p.textureCache[textureName] = image
 	}
-	return ""
+	return texture, nil
+}
+
+func generateBlockStateColor(texture image.Image) color.Color {
+	var sum colorSum
+	sum.addTexture(texture, texture.Bounds(), 1)
+	return sum.color()
 }
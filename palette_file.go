@@ -0,0 +1,516 @@
+package carto
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Tnze/go-mc/save"
+)
+
+// PaletteFile is a precomputed set of block and biome colors, it can be
+// dumped from a client JAR and used to render without one
+type PaletteFile struct {
+	Blocks []PaletteFileBlock          `json:"blocks"`
+	Biomes map[string]PaletteFileBiome `json:"biomes,omitempty"`
+
+	// index maps block names to their entries, keyed by variant properties
+	index map[string]map[string]resolvedBlockState
+}
+
+type PaletteFileBlock struct {
+	Name string `json:"name"`
+
+	// Properties uses the blockstate variant syntax, e.g. axis=y,lit=true
+	Properties string `json:"properties,omitempty"`
+	Color      string `json:"color"`
+	SideColor  string `json:"sideColor,omitempty"`
+	Tinted     bool   `json:"tinted,omitempty"`
+
+	// SideTinted is nil in palettes written before it was added, their
+	// sides are tinted along with the top
+	SideTinted *bool `json:"sideTinted,omitempty"`
+}
+
+func (b *PaletteFileBlock) sideTinted() bool {
+	if b.SideTinted == nil {
+		return b.Tinted
+	}
+	return *b.SideTinted
+}
+
+type PaletteFileBiome struct {
+	Grass   string `json:"grass,omitempty"`
+	Foliage string `json:"foliage,omitempty"`
+	Water   string `json:"water,omitempty"`
+}
+
+var paletteCSVHeader = []string{"name", "properties", "color", "side_color", "tinted", "side_tinted"}
+
+// paletteCSVBiomeHeader starts the rows holding biome colors, which follow the
+// blocks in a csv palette
+var paletteCSVBiomeHeader = []string{"biome", "grass", "foliage", "water"}
+
+// LoadPaletteFile reads a palette written by WritePaletteFile, files ending in
+// .csv are read as csv
+func LoadPaletteFile(filePath string) (*PaletteFile, error) {
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var file PaletteFile
+	if strings.EqualFold(filepath.Ext(filePath), ".csv") {
+		err = file.readCSV(fd)
+	} else {
+		err = json.NewDecoder(fd).Decode(&file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read palette %s: %v", filePath, err)
+	}
+
+	err = file.buildIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read palette %s: %v", filePath, err)
+	}
+	return &file, nil
+}
+
+// WritePaletteFile writes a palette as json or csv
+func WritePaletteFile(filePath, format string, file *PaletteFile) error {
+	fd, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	switch format {
+	case "csv":
+		return file.writeCSV(fd)
+	case "json":
+		encoder := json.NewEncoder(fd)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(file)
+	}
+	return fmt.Errorf("unsupported palette format '%s'", format)
+}
+
+func (f *PaletteFile) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	biomes := false
+	for idx, record := range records {
+		if idx == 0 && len(record) > 0 && record[0] == paletteCSVHeader[0] {
+			continue
+		}
+
+		if slices.Equal(record, paletteCSVBiomeHeader) {
+			biomes = true
+			continue
+		}
+
+		if biomes {
+			if len(record) != len(paletteCSVBiomeHeader) {
+				return fmt.Errorf("line %d: expected %d columns", idx+1, len(paletteCSVBiomeHeader))
+			}
+
+			if f.Biomes == nil {
+				f.Biomes = make(map[string]PaletteFileBiome)
+			}
+			f.Biomes[record[0]] = PaletteFileBiome{Grass: record[1], Foliage: record[2], Water: record[3]}
+			continue
+		}
+
+		// palettes written before side_tinted was added have one column less
+		if len(record) != len(paletteCSVHeader) && len(record) != len(paletteCSVHeader)-1 {
+			return fmt.Errorf("line %d: expected %d columns", idx+1, len(paletteCSVHeader))
+		}
+
+		tinted, _ := strconv.ParseBool(record[4])
+		block := PaletteFileBlock{
+			Name:       record[0],
+			Properties: record[1],
+			Color:      record[2],
+			SideColor:  record[3],
+			Tinted:     tinted,
+		}
+		if len(record) == len(paletteCSVHeader) {
+			sideTinted, _ := strconv.ParseBool(record[5])
+			block.SideTinted = &sideTinted
+		}
+		f.Blocks = append(f.Blocks, block)
+	}
+	return nil
+}
+
+func (f *PaletteFile) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(paletteCSVHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range f.Blocks {
+		err = writer.Write([]string{block.Name, block.Properties, block.Color, block.SideColor, strconv.FormatBool(block.Tinted), strconv.FormatBool(block.sideTinted())})
+		if err != nil {
+			return err
+		}
+	}
+
+	// without biomes tinted blocks would use the default biome everywhere
+	if len(f.Biomes) > 0 {
+		err = writer.Write(paletteCSVBiomeHeader)
+		if err != nil {
+			return err
+		}
+
+		names := make(map[string]struct{}, len(f.Biomes))
+		for name := range f.Biomes {
+			names[name] = struct{}{}
+		}
+
+		for _, name := range sortedKeys(names) {
+			biome := f.Biomes[name]
+			err = writer.Write([]string{name, biome.Grass, biome.Foliage, biome.Water})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (f *PaletteFile) buildIndex() error {
+	f.index = make(map[string]map[string]resolvedBlockState)
+	for _, block := range f.Blocks {
+		top, err := parseHexColor(block.Color)
+		if err != nil {
+			return fmt.Errorf("block %s: %v", block.Name, err)
+		}
+
+		var side color.Color = top
+		if block.SideColor != "" {
+			side, err = parseHexColor(block.SideColor)
+			if err != nil {
+				return fmt.Errorf("block %s: %v", block.Name, err)
+			}
+		}
+
+		name := namespacedName(block.Name)
+		if f.index[name] == nil {
+			f.index[name] = make(map[string]resolvedBlockState)
+		}
+		f.index[name][block.Properties] = resolvedBlockState{top: top, side: side, tinted: block.Tinted, sideTinted: block.sideTinted()}
+	}
+
+	for name, biome := range f.Biomes {
+		for _, value := range []string{biome.Grass, biome.Foliage, biome.Water} {
+			if value == "" {
+				continue
+			}
+
+			if _, err := parseHexColor(value); err != nil {
+				return fmt.Errorf("biome %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// findBlock returns the entry with the most properties in common with a block
+// state, the same way blockstate variants are matched
+func (f *PaletteFile) findBlock(name string, properties map[string]string) (resolvedBlockState, bool) {
+	entries, ok := f.index[name]
+	if !ok {
+		return resolvedBlockState{}, false
+	}
+
+	bestKey := ""
+	bestConditions := -1
+	for key := range entries {
+		conditions := parseVariantProperties(key)
+
+		matches := true
+		for k, v := range conditions {
+			if properties[k] != v {
+				matches = false
+				break
+			}
+		}
+
+		if matches && (len(conditions) > bestConditions || (len(conditions) == bestConditions && key < bestKey)) {
+			bestKey = key
+			bestConditions = len(conditions)
+		}
+	}
+
+	if bestConditions == -1 {
+		return resolvedBlockState{}, false
+	}
+	return entries[bestKey], true
+}
+
+// biomeColor returns a precomputed tint for a biome, kind is grass, foliage or water
+func (f *PaletteFile) biomeColor(biome save.BiomeState, kind string) (color.Color, bool) {
+	if f == nil {
+		return nil, false
+	}
+
+	colors, ok := f.Biomes[string(biome)]
+	if !ok {
+		return nil, false
+	}
+
+	var value string
+	switch kind {
+	case "grass":
+		value = colors.Grass
+	case "foliage":
+		value = colors.Foliage
+	case "water":
+		value = colors.Water
+	}
+
+	if value == "" {
+		return nil, false
+	}
+
+	clr, err := parseHexColor(value)
+	if err != nil {
+		return nil, false
+	}
+	return clr, true
+}
+
+// ColorOverride replaces the color of every block state matching a pattern
+type ColorOverride struct {
+	Pattern string
+
+	// name is a block name which may contain wildcards, properties must all
+	// match for the override to apply
+	name       string
+	properties map[string]string
+	color      color.Color
+}
+
+// ParseColorOverride parses a pattern such as minecraft:oak_log[axis=y] or
+// minecraft:*_leaves along with a hex color
+func ParseColorOverride(pattern, value string) (ColorOverride, error) {
+	clr, err := parseHexColor(value)
+	if err != nil {
+		return ColorOverride{}, fmt.Errorf("override %s: %v", pattern, err)
+	}
+
+	name := pattern
+	properties := make(map[string]string)
+	if start := strings.Index(pattern, "["); start != -1 {
+		if !strings.HasSuffix(pattern, "]") {
+			return ColorOverride{}, fmt.Errorf("override %s: missing closing ]", pattern)
+		}
+
+		name = pattern[:start]
+		properties = parseVariantProperties(pattern[start+1 : len(pattern)-1])
+	}
+
+	name = namespacedName(name)
+	if _, err := path.Match(name, ""); err != nil {
+		return ColorOverride{}, fmt.Errorf("override %s: %v", pattern, err)
+	}
+
+	return ColorOverride{
+		Pattern:    pattern,
+		name:       name,
+		properties: properties,
+		color:      clr,
+	}, nil
+}
+
+func (o *ColorOverride) matches(name string, properties map[string]string) bool {
+	if ok, _ := path.Match(o.name, name); !ok {
+		return false
+	}
+
+	for k, v := range o.properties {
+		if properties[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// specificity ranks overrides so exact names beat wildcards and more
+// properties beat fewer
+func (o *ColorOverride) specificity() int {
+	score := len(o.properties) * 2
+	if !strings.ContainsAny(o.name, "*?[") {
+		score++
+	}
+	return score
+}
+
+// matchColorOverride returns the color of the most specific override matching a block state
+func matchColorOverride(overrides []ColorOverride, name string, properties map[string]string) (color.Color, bool) {
+	var best *ColorOverride
+	for idx := range overrides {
+		override := &overrides[idx]
+		if !override.matches(name, properties) {
+			continue
+		}
+
+		if best == nil || override.specificity() > best.specificity() ||
+			(override.specificity() == best.specificity() && override.Pattern < best.Pattern) {
+			best = override
+		}
+	}
+
+	if best == nil {
+		return nil, false
+	}
+	return best.color, true
+}
+
+// parseHexColor parses #rrggbb or #rrggbbaa
+func parseHexColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color '%s'", value)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color '%s'", value)
+	}
+
+	if len(hex) == 6 {
+		return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// formatHexColor formats a color as #rrggbb, or #rrggbbaa if it is not opaque
+func formatHexColor(clr color.Color) string {
+	c := color.NRGBAModel.Convert(clr).(color.NRGBA)
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// Dump resolves the color of every blockstate variant and biome in the assets.
+// Multipart blocks get a single entry matching all of their states, resolved
+// with properties under which one of their parts applies.
+func (p *Palette) Dump() *PaletteFile {
+	p.Lock()
+	defer p.Unlock()
+
+	names := []string{}
+	for filePath := range p.loader.Files {
+		// assets/<namespace>/blockstates/<name>.json
+		parts := strings.SplitN(filePath, "/", 4)
+		if len(parts) == 4 && parts[0] == "assets" && parts[2] == "blockstates" && strings.HasSuffix(parts[3], ".json") {
+			names = append(names, parts[1]+":"+strings.TrimSuffix(parts[3], ".json"))
+		}
+	}
+	sort.Strings(names)
+
+	file := &PaletteFile{
+		Blocks: []PaletteFileBlock{},
+		Biomes: make(map[string]PaletteFileBiome),
+	}
+
+	for _, name := range names {
+		if isAirBlock(name) {
+			continue
+		}
+
+		keys := []string{""}
+		properties := map[string]map[string]string{"": {}}
+		if data, err := p.loader.LoadRaw(assetPath("assets", "blockstates", name, "json")); err == nil {
+			var info BlockStateInfo
+			if json.Unmarshal(data, &info) == nil && info.Multipart == nil {
+				keys = keys[:0]
+				for key := range info.Variants {
+					keys = append(keys, key)
+					properties[key] = parseVariantProperties(key)
+				}
+				sort.Strings(keys)
+			} else if info.Multipart != nil {
+				if props, err := multipartProperties(info.Multipart); err == nil {
+					properties[""] = props
+				}
+			}
+		}
+
+		for _, key := range keys {
+			resolved, err := p.resolveBlockState(name, properties[key])
+			if err != nil {
+				p.missingLock.Lock()
+				p.missingBlockStates[name] = struct{}{}
+				p.missingLock.Unlock()
+				continue
+			}
+
+			file.Blocks = append(file.Blocks, PaletteFileBlock{
+				Name:       name,
+				Properties: key,
+				Color:      formatHexColor(resolved.top),
+				SideColor:  formatHexColor(resolved.side),
+				Tinted:     resolved.tinted,
+				SideTinted: &resolved.sideTinted,
+			})
+		}
+	}
+
+	// blocks only known to a precomputed palette are carried over as is
+	if p.loader.Precomputed != nil {
+		dumped := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			dumped[name] = struct{}{}
+		}
+
+		for _, block := range p.loader.Precomputed.Blocks {
+			if _, ok := dumped[namespacedName(block.Name)]; !ok {
+				file.Blocks = append(file.Blocks, block)
+			}
+		}
+
+		for name, colors := range p.loader.Precomputed.Biomes {
+			file.Biomes[name] = colors
+		}
+	}
+
+	for _, biome := range listBiomes(p.loader) {
+		state := save.BiomeState(biome)
+
+		colors := PaletteFileBiome{
+			Water: formatHexColor(p.waterColor(state)),
+		}
+		if grass := p.grassColor(state); grass != nil {
+			colors.Grass = formatHexColor(grass)
+		}
+		if foliage := p.foliageColor(state); foliage != nil {
+			colors.Foliage = formatHexColor(foliage)
+		}
+		file.Biomes[biome] = colors
+	}
+
+	return file
+}
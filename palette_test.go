@@ -0,0 +1,255 @@
+package carto
+
+import (
+	"image/color"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Tnze/go-mc/save"
+)
+
+// testPackPalette is a palette over the small resource pack under testdata/pack
+func testPackPalette(t *testing.T) *Palette {
+	loader := NewAssetLoader()
+	err := loader.AddPack(filepath.Join("testdata", "pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	palette, err := NewPalette(loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return palette
+}
+
+func TestLoadModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+
+		// textures maps the texture variables of the model to what they resolve to
+		textures map[string]string
+		elements int
+	}{
+		{
+			name:   "minecraft:block/stone",
+			parent: "minecraft:block/cube_all",
+			textures: map[string]string{
+				"all":      "minecraft:block/stone",
+				"up":       "minecraft:block/stone",
+				"north":    "minecraft:block/stone",
+				"particle": "minecraft:block/stone",
+			},
+			elements: 1,
+		},
+		{
+			name:   "minecraft:block/oak_log",
+			parent: "minecraft:block/cube_column",
+			textures: map[string]string{
+				"up":       "minecraft:block/oak_log_top",
+				"down":     "minecraft:block/oak_log_top",
+				"east":     "minecraft:block/oak_log",
+				"particle": "minecraft:block/oak_log",
+			},
+			elements: 1,
+		},
+		{
+			name:   "minecraft:block/grass_block",
+			parent: "block/block",
+			textures: map[string]string{
+				"top":     "minecraft:block/grass_block_top",
+				"side":    "minecraft:block/grass_block_side",
+				"overlay": "minecraft:block/grass_block_side_overlay",
+			},
+			elements: 2,
+		},
+	}
+
+	palette := testPackPalette(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model, err := palette.loadModel(test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if model.Parent != test.parent {
+				t.Errorf("parent = %s, want %s", model.Parent, test.parent)
+			}
+			if len(model.Elements) != test.elements {
+				t.Errorf("model has %d elements, want %d inherited from its parents", len(model.Elements), test.elements)
+			}
+
+			for key, want := range test.textures {
+				if texture, ok := model.resolveTexture(key); !ok || texture != want {
+					t.Errorf("texture #%s = %q, want %q", key, texture, want)
+				}
+			}
+		})
+	}
+
+	_, err := palette.loadModel("minecraft:block/cycle_a")
+	if err == nil || !strings.Contains(err.Error(), "too many parents") {
+		t.Errorf("loading a model which is its own parent returned %v", err)
+	}
+
+	_, err = palette.loadModel("minecraft:block/missing")
+	if err == nil {
+		t.Errorf("loading a missing model succeeded")
+	}
+}
+
+func TestResolveTexture(t *testing.T) {
+	model := ModelInfo{Textures: map[string]string{
+		"all":      "block/stone",
+		"side":     "#all",
+		"north":    "#side",
+		"modded":   "example:block/ore",
+		"loop":     "#other",
+		"other":    "#loop",
+		"dangling": "#missing",
+		"empty":    "",
+	}}
+
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{key: "all", want: "minecraft:block/stone", ok: true},
+		{key: "north", want: "minecraft:block/stone", ok: true},
+		{key: "modded", want: "example:block/ore", ok: true},
+		{key: "loop"},
+		{key: "dangling"},
+		{key: "empty"},
+		{key: "missing"},
+	}
+
+	for _, test := range tests {
+		texture, ok := model.resolveTexture(test.key)
+		if texture != test.want || ok != test.ok {
+			t.Errorf("resolveTexture(%q) = %q, %v, want %q, %v", test.key, texture, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestPaletteModelColors(t *testing.T) {
+	// the plains biome of the pack sets its grass and foliage colors
+	grass := color.NRGBA{R: 0x79, G: 0xc0, B: 0x5a, A: 0xff}
+	foliage := color.NRGBA{R: 0x59, G: 0xae, B: 0x30, A: 0xff}
+
+	tests := []struct {
+		block       string
+		texture     string
+		sideTexture string
+		top, side   color.Color
+	}{
+		{
+			block:       "minecraft:stone",
+			texture:     "minecraft:block/stone",
+			sideTexture: "minecraft:block/stone",
+			top:         color.NRGBA{R: 0x7d, G: 0x7d, B: 0x7d, A: 0xff},
+			side:        color.NRGBA{R: 0x7d, G: 0x7d, B: 0x7d, A: 0xff},
+		},
+		{
+			block:       "minecraft:oak_log",
+			texture:     "minecraft:block/oak_log_top",
+			sideTexture: "minecraft:block/oak_log",
+			top:         color.NRGBA{R: 0xa0, G: 0x80, B: 0x50, A: 0xff},
+			side:        color.NRGBA{R: 0x6b, G: 0x52, B: 0x30, A: 0xff},
+		},
+		{
+			// only the overlay on the sides is tinted, the dirt below is not
+			block:       "minecraft:grass_block",
+			texture:     "minecraft:block/grass_block_top",
+			sideTexture: "minecraft:block/grass_block_side",
+			top:         grass,
+			side:        color.NRGBA{R: 0x86, G: 0x60, B: 0x43, A: 0xff},
+		},
+		{
+			block:       "minecraft:oak_leaves",
+			texture:     "minecraft:block/oak_leaves",
+			sideTexture: "minecraft:block/oak_leaves",
+			top:         foliage,
+			side:        foliage,
+		},
+	}
+
+	palette := testPackPalette(t)
+	for _, test := range tests {
+		t.Run(test.block, func(t *testing.T) {
+			state := save.BlockState{Name: test.block}
+			palette.Prepare(save.Section{BlockStates: save.PaletteContainer[save.BlockState]{Palette: []save.BlockState{state}}})
+
+			stateStr := state.Name + "/" + state.Properties.String()
+			if texture := palette.blockStateTextures[stateStr]; texture != test.texture {
+				t.Errorf("top texture = %s, want %s", texture, test.texture)
+			}
+			if texture := palette.blockStateSideTextures[stateStr]; texture != test.sideTexture {
+				t.Errorf("side texture = %s, want %s", texture, test.sideTexture)
+			}
+
+			assertColor(t, "top", palette.GetColor(state, "minecraft:plains"), test.top)
+			assertColor(t, "side", palette.GetSideColor(state, "minecraft:plains"), test.side)
+		})
+	}
+}
+
+// assertColor compares colors at 8 bits per channel
+func assertColor(t *testing.T, name string, got, want color.Color) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s color is nil, want %v", name, want)
+		return
+	}
+
+	r, g, b, a := got.RGBA()
+	wr, wg, wb, wa := want.RGBA()
+	if r>>8 != wr>>8 || g>>8 != wg>>8 || b>>8 != wb>>8 || a>>8 != wa>>8 {
+		t.Errorf("%s color = %v, want %v", name, got, want)
+	}
+}
+
+func TestPaletteFileCSV(t *testing.T) {
+	sideTinted := false
+	file := &PaletteFile{
+		Blocks: []PaletteFileBlock{
+			{Name: "minecraft:stone", Color: "#7d7d7d"},
+			{Name: "minecraft:grass_block", Properties: "snowy=false", Color: "#7f7f7f", SideColor: "#866043", Tinted: true, SideTinted: &sideTinted},
+		},
+		Biomes: map[string]PaletteFileBiome{
+			"minecraft:plains":   {Grass: "#91bd59", Foliage: "#77ab2f", Water: "#3f76e4"},
+			"minecraft:the_void": {Water: "#3f76e4"},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "palette.csv")
+	err := WritePaletteFile(path, "csv", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadPaletteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks without a side tint read back with it set from tinted
+	stoneSideTinted := false
+	file.Blocks[0].SideTinted = &stoneSideTinted
+	if !reflect.DeepEqual(loaded.Blocks, file.Blocks) {
+		t.Errorf("blocks = %+v, want %+v", loaded.Blocks, file.Blocks)
+	}
+	if !reflect.DeepEqual(loaded.Biomes, file.Biomes) {
+		t.Errorf("biomes = %+v, want %+v", loaded.Biomes, file.Biomes)
+	}
+
+	if clr, ok := loaded.biomeColor("minecraft:plains", "grass"); !ok {
+		t.Errorf("no grass color read for plains")
+	} else {
+		assertColor(t, "grass", clr, color.NRGBA{R: 0x91, G: 0xbd, B: 0x59, A: 0xff})
+	}
+}
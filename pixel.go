@@ -1,44 +1,181 @@
 package carto
 
 import (
+	"fmt"
 	"image"
 	"image/color"
-	"math/bits"
+	"image/draw"
+	"math"
 	"sync"
 
 	"github.com/Tnze/go-mc/level"
 	"github.com/Tnze/go-mc/save"
 )
 
+// pixelChunk is what shading and blending need from a rendered chunk until its
+// region is finished
+type pixelChunk struct {
+	minY    int
+	heights *level.BitStorage
+	biomes  [256]save.BiomeState
+	columns [256]blendColumn
+}
+
 type ChunkPixelRenderer struct {
 	sync.Mutex
 
-	opts               *ChunkRenderOpts
-	shader             *ChunkPixelShader
-	palette            *Palette
-	missingBlockStates map[string]struct{}
+	opts    *ChunkRenderOpts
+	shader  *ChunkPixelShader
+	blender *ChunkBiomeBlender
+	palette *Palette
 
 	stripCeiling bool
+
+	// minY and maxY limit the range of blocks scanned in world coordinates
+	minY  int
+	maxY  int
+	caves bool
+
+	// pending holds the chunks rendered since their region was last stored
+	pending map[coord]*pixelChunk
 }
 
-func NewChunkPixelRenderer(opts *ChunkRenderOpts, assetLoader *AssetLoader) *ChunkPixelRenderer {
-	palette := NewPalette(assetLoader)
-	shader := NewChunkPixelShader()
-	return &ChunkPixelRenderer{
-		opts:               opts,
-		shader:             shader,
-		palette:            palette,
-		missingBlockStates: make(map[string]struct{}),
-		stripCeiling:       opts.GetBool("strip-ceiling", false),
+func init() {
+	RegisterRenderer(RendererInfo{
+		Name:        "pixel",
+		Description: "top-down terrain with one averaged block color per column",
+		Options: []RendererOption{
+			{Name: "shading", Default: "relief", Description: "relief to light slopes from light-azimuth, flat (or true) to only darken the low side of steps, false to disable"},
+			{Name: "light-azimuth", Default: "315", Description: "direction the shading light comes from, degrees clockwise from north"},
+			{Name: "light-altitude", Default: "45", Description: "angle of the shading light above the horizon in degrees"},
+			{Name: "shading-strength", Default: "0.5", Description: "how strongly slopes are lightened and darkened"},
+			{Name: "strip-ceiling", Default: "false", Description: "skip past the first solid layer, useful for the nether roof"},
+			{Name: "min-y", Default: "", Description: "lowest y level scanned, defaults to the bottom of the world"},
+			{Name: "max-y", Default: "", Description: "y level to start scanning down from instead of the surface"},
+			{Name: "caves", Default: "false", Description: "only show blocks with air directly above them, combine with max-y to map caves"},
+			{Name: "biome-blend", Default: "0", Description: "average grass, foliage and water colors over this many blocks in each direction"},
+			{Name: "unknown-color", Default: "#ff00ff", Description: "color drawn for blocks missing from the assets, none to skip them"},
+		},
+		Factory: func(opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error) {
+			renderer, err := NewChunkPixelRenderer(opts, assetLoader)
+			if err != nil {
+				return nil, err
+			}
+			return renderer, nil
+		},
+	})
+}
+
+func NewChunkPixelRenderer(opts *ChunkRenderOpts, assetLoader *AssetLoader) (*ChunkPixelRenderer, error) {
+	palette, err := NewPalette(assetLoader)
+	if err != nil {
+		return nil, err
+	}
+	palette.placeholder = opts.GetColor("unknown-color", DefaultPlaceholderColor)
+
+	var blender *ChunkBiomeBlender
+	if radius := opts.GetInt("biome-blend", 0); radius > 0 {
+		blender = NewChunkBiomeBlender(palette, radius)
+	}
+
+	// true is kept from when shading had a single style, which flat matches
+	style := opts.GetString("shading", "relief")
+	if style == "true" {
+		style = "flat"
+	}
+
+	var shader *ChunkPixelShader
+	if style != "false" {
+		shader, err = NewChunkPixelShader(ShadeOpts{
+			Style:    style,
+			Azimuth:  opts.GetFloat("light-azimuth", 315),
+			Altitude: opts.GetFloat("light-altitude", 45),
+			Strength: opts.GetFloat("shading-strength", 0.5),
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	return &ChunkPixelRenderer{
+		opts:         opts,
+		shader:       shader,
+		blender:      blender,
+		palette:      palette,
+		stripCeiling: opts.GetBool("strip-ceiling", false),
+		minY:         opts.GetInt("min-y", math.MinInt32),
+		maxY:         opts.GetInt("max-y", math.MaxInt32),
+		caves:        opts.GetBool("caves", false),
+		pending:      make(map[coord]*pixelChunk),
+	}, nil
 }
 
 func (c *ChunkPixelRenderer) Finalize(path string) error {
-	if !c.opts.GetBool("shading", true) {
+	// chunks of regions which failed to render are never finished
+	c.Lock()
+	c.pending = make(map[coord]*pixelChunk)
+	c.Unlock()
+	return nil
+}
+
+// FinishMargin covers the neighbors shading compares heights with and the
+// columns blending averages over
+func (c *ChunkPixelRenderer) FinishMargin() int {
+	margin := 0
+	if c.shader != nil {
+		margin = 1
+	}
+	if c.blender != nil {
+		margin = max(margin, c.blender.radius)
+	}
+	return margin
+}
+
+// StoreRegion keeps the heights, biomes and tints of a region in a sidecar so
+// chunks along its edges can be finished against it, including when a
+// neighboring region is rendered in a later build
+func (c *ChunkPixelRenderer) StoreRegion(dst string, x, z int, drawn []coord) error {
+	c.Lock()
+	chunks := takeRegionChunks(c.pending, x, z)
+	c.Unlock()
+
+	return storeRegionColumns(dst, x, z, drawn, chunks, func(columns *regionColumns, crd coord, chunk *pixelChunk) {
+		columns.setChunk(crd, chunk.minY, chunk.heights, &chunk.biomes, &chunk.columns)
+	})
+}
+
+// FinishRegion blends and shades the chunks of a region drawn in this pass
+func (c *ChunkPixelRenderer) FinishRegion(dst string, x, z int, img draw.Image, drawn []coord) error {
+	if c.shader == nil && c.blender == nil {
 		return nil
 	}
 
-	return c.shader.Render(path)
+	columns := loadRegionColumns(dst, x, z)
+	if columns == nil {
+		return fmt.Errorf("failed to load region columns (%v, %v)", x, z)
+	}
+
+	neighborhood := newRegionNeighborhood(dst, x, z, columns)
+
+	// blending replaces pixels so it must happen before the shading is overlaid
+	if c.blender != nil {
+		c.blender.blendRegion(img, neighborhood, drawn)
+	}
+
+	if c.shader != nil {
+		for _, crd := range drawn {
+			// chunks which were cleared have nothing to shade
+			if !columns.hasChunk(crd) {
+				continue
+			}
+
+			c.shader.shadeChunk(img, image.Point{crd.X * 16, crd.Z * 16}, func(x, z int) (int, bool) {
+				return neighborhood.height(crd.X*16+x, crd.Z*16+z)
+			})
+		}
+	}
+
+	return nil
 }
 
 func (c *ChunkPixelRenderer) ImageSize() (int, int) {
@@ -46,30 +183,46 @@ func (c *ChunkPixelRenderer) ImageSize() (int, int) {
 }
 
 func (c *ChunkPixelRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error) {
-	bitsForHeight := bits.Len(uint(len(chunk.Sections))*16 + 1)
-	motionBlocking := level.NewBitStorage(bitsForHeight, 16*16, chunk.Heightmaps["MOTION_BLOCKING"])
-	oceanFloor := level.NewBitStorage(bitsForHeight, 16*16, chunk.Heightmaps["OCEAN_FLOOR"])
+	motionBlocking := chunkHeightmap(chunk, "MOTION_BLOCKING")
+	oceanFloor := chunkHeightmap(chunk, "OCEAN_FLOOR")
 
 	if len(chunk.Sections) == 0 {
 		return nil, nil
 	}
 
-	c.shader.add(int(chunk.XPos), int(chunk.ZPos), motionBlocking)
+	// when not rendering the surface we shade based on the height of the drawn blocks
+	sliced := c.stripCeiling || c.caves || c.maxY != math.MaxInt32
+	heights := motionBlocking
+	if sliced {
+		heights = newChunkHeightmap(chunk)
+	}
 
 	img := image.NewRGBA64(image.Rect(0, 0, 16, 16))
 
 	cache := newSectionCache(c.palette, chunk)
 
+	// heightmap values are relative to the bottom of the world
+	minY := cache.minY
+	pending := &pixelChunk{minY: minY, heights: heights}
+	yEnd := minY
+	if c.minY > yEnd {
+		yEnd = c.minY
+	}
+
 	for x := 0; x < 16; x++ {
 		for z := 0; z < 16; z++ {
 			heightmapIndex := ((z) * 16) + x
-			yStart := motionBlocking.Get(heightmapIndex)
+			surfaceY := motionBlocking.Get(heightmapIndex) + minY
+			yStart := surfaceY
+			if c.maxY < yStart {
+				yStart = c.maxY
+			}
+
 			underCeiling := false
+			openAbove := yStart >= surfaceY
 
-			for y := yStart; y > 1; y-- {
-				sectionIndex := y / 16
-				sectionY := y % 16
-				sc := cache.get(sectionIndex)
+			for y := yStart; y >= yEnd; y-- {
+				sc := cache.getBlock(y)
 				if sc == nil {
 					continue
 				}
@@ -78,9 +231,9 @@ func (c *ChunkPixelRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error)
 					continue
 				}
 
-				blockIndex := ((((sectionY) * 16) + z) * 16) + x
+				blockIndex := blockIndex(x, y, z)
 				blockState := sc.section.BlockStates.Palette[sc.storage.Get(blockIndex)]
-				biomeState := sc.section.Biomes.Palette[sc.biomes.Get(blockIndex)]
+				biomeState := sc.biome(x, y, z)
 
 				// if we're stripping the ceiling we need to wait for the first airblock
 				if c.stripCeiling && !underCeiling {
@@ -92,24 +245,36 @@ func (c *ChunkPixelRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error)
 				}
 
 				if isAirBlock(blockState.Name) {
+					openAbove = true
+					continue
+				}
+
+				// for caves we only want the floor, so wait for a block with air above it
+				if c.caves && !openAbove {
 					continue
 				}
 
 				clr := c.palette.GetColor(blockState, biomeState)
 				if clr == nil {
-					c.Lock()
-					c.missingBlockStates[blockState.Name] = struct{}{}
-					c.Unlock()
 					continue
 				}
 
+				pending.biomes[heightmapIndex] = biomeState
+				if c.blender != nil {
+					pending.columns[heightmapIndex].kind = c.palette.getTintKind(blockState)
+				}
+
 				// for water we want to darken things based on the depth of the water
 				if blockState.Name == "minecraft:water" {
-					oceanFloorY := oceanFloor.Get(heightmapIndex)
-					d := (y - oceanFloorY) * 8
-					if d > 128 {
-						d = 128
+					oceanFloorY := oceanFloor.Get(heightmapIndex) + minY
+
+					// slices can draw water below the surfaces ocean floor
+					if sliced {
+						oceanFloorY = waterBottom(cache, x, y, z, yEnd)
 					}
+					d := clampInt((y-oceanFloorY)*8, 0, 128)
+					pending.columns[heightmapIndex].darkened = true
+					pending.columns[heightmapIndex].depth = uint8(d)
 					clr = combineColor(clr, color.RGBA{
 						R: 0,
 						G: 0,
@@ -119,20 +284,45 @@ func (c *ChunkPixelRenderer) RenderChunk(chunk *save.Chunk) (image.Image, error)
 				}
 
 				img.Set(x, z, clr)
+				if sliced {
+					heights.Set(heightmapIndex, y+1-minY)
+				}
 				break
 			}
 		}
 	}
 
+	if c.shader != nil || c.blender != nil {
+		c.Lock()
+		c.pending[coord{X: int(chunk.XPos), Z: int(chunk.ZPos)}] = pending
+		c.Unlock()
+	}
+
 	return img, nil
 }
 
-func (c *ChunkPixelRenderer) GetMissingBlockStates() []string {
-	result := []string{}
-	for k := range c.missingBlockStates {
-		result = append(result, k)
+// waterBottom returns the lowest y of the water column containing a block,
+// scanning no further down than yEnd
+func waterBottom(cache *sectionCache, x, y, z, yEnd int) int {
+	for ; y > yEnd; y-- {
+		sc := cache.getBlock(y - 1)
+		if sc == nil || len(sc.section.BlockStates.Palette) == 0 {
+			break
+		}
+
+		if sc.section.BlockStates.Palette[sc.storage.Get(blockIndex(x, y-1, z))].Name != "minecraft:water" {
+			break
+		}
 	}
-	return result
+	return y
+}
+
+func (c *ChunkPixelRenderer) GetMissingBlockStates() []string {
+	return c.palette.GetMissingBlockStates()
+}
+
+func (c *ChunkPixelRenderer) GetMissingBiomes() []string {
+	return c.palette.GetMissingBiomes()
 }
 
 func calcBitsPerValue(length, longs int) (bits int) {
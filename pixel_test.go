@@ -0,0 +1,178 @@
+package carto
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/Tnze/go-mc/save"
+)
+
+// testPalette is a precomputed palette so renderers can be tested without a client jar
+func testPalette(t *testing.T) *AssetLoader {
+	file := &PaletteFile{
+		Blocks: []PaletteFileBlock{
+			{Name: "minecraft:bedrock", Color: "#101010"},
+			{Name: "minecraft:stone", Color: "#808080"},
+			{Name: "minecraft:deepslate", Color: "#404048"},
+			{Name: "minecraft:sand", Color: "#dbcfa3"},
+			{Name: "minecraft:grass_block", Color: "#7f7f7f", SideColor: "#866043", Tinted: true},
+			{Name: "minecraft:water", Color: "#ffffff", Tinted: true},
+		},
+		Biomes: map[string]PaletteFileBiome{
+			"minecraft:plains": {Grass: "#91bd59", Foliage: "#77ab2f", Water: "#3f76e4"},
+			"minecraft:swamp":  {Grass: "#6a7039", Foliage: "#6a7039", Water: "#617b64"},
+		},
+	}
+	if err := file.buildIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewAssetLoader()
+	loader.Precomputed = file
+	return loader
+}
+
+func TestPixelRenderChunk(t *testing.T) {
+	water := combineColor(color.NRGBA{R: 0x3f, G: 0x76, B: 0xe4, A: 0xff}, color.RGBA{A: 3 * 8})
+
+	tests := []struct {
+		name  string
+		chunk *save.Chunk
+		opts  map[string]string
+		want  color.Color
+
+		// fixture names a chunk under testdata/chunks used in place of chunk
+		fixture string
+	}{
+		{
+			name:  "world from y 0 surface",
+			chunk: testChunk(0, -1, 15, map[int]string{0: "minecraft:bedrock", 62: "minecraft:stone"}, "minecraft:plains"),
+			want:  color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff},
+		},
+		{
+			name:  "1.20 surface",
+			chunk: testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", -20: "minecraft:deepslate", 62: "minecraft:stone"}, "minecraft:plains"),
+			want:  color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff},
+		},
+		{
+			name:  "1.20 surface below zero",
+			chunk: testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", -20: "minecraft:deepslate"}, "minecraft:plains"),
+			want:  color.NRGBA{R: 0x40, G: 0x40, B: 0x48, A: 0xff},
+		},
+		{
+			name:  "1.20 max-y below zero",
+			chunk: testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", -20: "minecraft:deepslate", 62: "minecraft:stone"}, "minecraft:plains"),
+			opts:  map[string]string{"max-y": "-10"},
+			want:  color.NRGBA{R: 0x40, G: 0x40, B: 0x48, A: 0xff},
+		},
+		{
+			name:  "1.20 min-y at the bottom of the world",
+			chunk: testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock"}, "minecraft:plains"),
+			opts:  map[string]string{"min-y": "-64"},
+			want:  color.NRGBA{R: 0x10, G: 0x10, B: 0x10, A: 0xff},
+		},
+		{
+			name: "1.20 water depth",
+			chunk: testChunk(-4, -4, 19, map[int]string{
+				-64: "minecraft:bedrock", 59: "minecraft:sand", 60: "minecraft:water", 61: "minecraft:water", 62: "minecraft:water", 63: "minecraft:water",
+			}, "minecraft:plains"),
+			want: water,
+		},
+		{
+			name: "1.20 sliced water depth",
+			chunk: testChunk(-4, -4, 19, map[int]string{
+				-64: "minecraft:bedrock", 59: "minecraft:sand", 60: "minecraft:water", 61: "minecraft:water", 62: "minecraft:water", 63: "minecraft:water",
+			}, "minecraft:plains"),
+			opts: map[string]string{"max-y": "63"},
+			want: water,
+		},
+		{
+			name:    "overworld fixture below the surface",
+			fixture: "overworld",
+			opts:    map[string]string{"max-y": "62"},
+			want:    color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff},
+		},
+		{
+			name:    "nether fixture",
+			fixture: "nether",
+			want:    color.NRGBA{R: 0x10, G: 0x10, B: 0x10, A: 0xff},
+		},
+		{
+			name:  "missing block",
+			chunk: testChunk(-4, -4, 19, map[int]string{62: "minecraft:unknown"}, "minecraft:plains"),
+			want:  DefaultPlaceholderColor,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := map[string]string{"shading": "false"}
+			for k, v := range test.opts {
+				opts[k] = v
+			}
+
+			renderer, err := NewChunkPixelRenderer(NewChunkRenderOpts(opts), testPalette(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chunk := test.chunk
+			if test.fixture != "" {
+				chunk = loadTestChunk(t, test.fixture)
+			}
+
+			img, err := renderer.RenderChunk(chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img == nil {
+				t.Fatal("no image rendered")
+			}
+
+			wr, wg, wb, wa := test.want.RGBA()
+			for _, pnt := range [][2]int{{0, 0}, {7, 9}, {15, 15}} {
+				r, g, b, a := img.At(pnt[0], pnt[1]).RGBA()
+				if r>>8 != wr>>8 || g>>8 != wg>>8 || b>>8 != wb>>8 || a>>8 != wa>>8 {
+					t.Errorf("pixel %v = %v, want %v", pnt, img.At(pnt[0], pnt[1]), test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPixelGrassTint(t *testing.T) {
+	chunk := testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", 62: "minecraft:grass_block"}, "minecraft:plains")
+
+	palette := []save.BiomeState{"minecraft:plains", "minecraft:swamp"}
+	cell := func(x, y, z int) int {
+		return (x + z) % 2
+	}
+	for idx := range chunk.Sections {
+		if chunk.Sections[idx].Y == 3 {
+			setTestBiomes(&chunk.Sections[idx], palette, 1, cell)
+		}
+	}
+
+	renderer, err := NewChunkPixelRenderer(NewChunkRenderOpts(map[string]string{"shading": "false"}), testPalette(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := renderer.RenderChunk(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grass := map[save.BiomeState]color.NRGBA{
+		"minecraft:plains": {R: 0x91, G: 0xbd, B: 0x59, A: 0xff},
+		"minecraft:swamp":  {R: 0x6a, G: 0x70, B: 0x39, A: 0xff},
+	}
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			want := grass[palette[cell(x/4, 3, z/4)]]
+			if got := color.NRGBAModel.Convert(img.At(x, z)); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, z, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package carto
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ChunkRendererFactory creates a new ChunkRenderer for a single layer
+type ChunkRendererFactory func(opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error)
+
+// RendererOption describes a single option accepted by a renderer
+type RendererOption struct {
+	Name        string
+	Default     string
+	Description string
+}
+
+// RendererInfo describes a registered renderer
+type RendererInfo struct {
+	Name        string
+	Description string
+	Options     []RendererOption
+	Factory     ChunkRendererFactory
+}
+
+var (
+	renderersLock sync.RWMutex
+	renderers     = make(map[string]*RendererInfo)
+)
+
+// RegisterRenderer makes a renderer available under the given name for use in
+// layer configuration. It panics if a renderer is registered twice or has no
+// factory.
+func RegisterRenderer(info RendererInfo) {
+	renderersLock.Lock()
+	defer renderersLock.Unlock()
+
+	if info.Factory == nil {
+		panic("carto: RegisterRenderer factory is nil for " + info.Name)
+	}
+
+	if _, ok := renderers[info.Name]; ok {
+		panic("carto: RegisterRenderer called twice for " + info.Name)
+	}
+
+	renderers[info.Name] = &info
+}
+
+// GetRenderer returns the renderer registered under the given name
+func GetRenderer(name string) (*RendererInfo, bool) {
+	renderersLock.RLock()
+	defer renderersLock.RUnlock()
+
+	info, ok := renderers[name]
+	return info, ok
+}
+
+// Renderers returns all registered renderers sorted by name
+func Renderers() []*RendererInfo {
+	renderersLock.RLock()
+	defer renderersLock.RUnlock()
+
+	result := make([]*RendererInfo, 0, len(renderers))
+	for _, info := range renderers {
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// NewChunkRenderer creates a ChunkRenderer using the renderer registered under the given name
+func NewChunkRenderer(name string, opts *ChunkRenderOpts, assetLoader *AssetLoader) (ChunkRenderer, error) {
+	info, ok := GetRenderer(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported renderer '%s'", name)
+	}
+
+	return info.Factory(opts, assetLoader)
+}
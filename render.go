@@ -8,9 +8,11 @@ import (
 	"image/png"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,6 +21,25 @@ import (
 	"github.com/Tnze/go-mc/save/region"
 )
 
+// RegionFinisher is implemented by renderers which post-process region images
+// using columns of the chunks around them. Every region rendered in a pass is
+// stored before any of them are finished, so the result does not depend on the
+// order regions are rendered in. Chunks within the finish margin of a changed
+// chunk are redrawn along with it, including those in neighboring regions.
+type RegionFinisher interface {
+	// FinishMargin is how many blocks around a column its finished pixel
+	// depends on, zero when the renderer has nothing to finish
+	FinishMargin() int
+
+	// StoreRegion saves the columns of the chunks drawn in a region, drawn
+	// holds their positions within the region
+	StoreRegion(dst string, x, z int, drawn []coord) error
+
+	// FinishRegion post-processes the chunks drawn in a region once every
+	// region around it in the pass has been stored
+	FinishRegion(dst string, x, z int, img draw.Image, drawn []coord) error
+}
+
 type Renderer struct {
 	chunk ChunkRenderer
 }
@@ -30,15 +51,177 @@ func NewRenderer(chunk ChunkRenderer) *Renderer {
 }
 
 func (r *Renderer) RenderWorld(src, dst string, opts WorldRenderOpts) (*WorldRenderResult, error) {
+	files, err := listRegionFiles(src, opts.Area)
+	if err != nil {
+		return nil, err
+	}
+	return r.renderRegionFiles(src, dst, files, opts)
+}
+
+// regionFile is a region file within a directory of region files
+type regionFile struct {
+	name       string
+	regionName string
+	crd        coord
+
+	// crdErr is set for region files without coordinates in their name, they
+	// are never limited by the area
+	crdErr error
+}
+
+// regionFiles parses the names of region files, skipping those outside the area
+func regionFiles(names []string, area *RenderArea) []regionFile {
+	files := []regionFile{}
+	for _, name := range names {
+		file := regionFile{name: name, regionName: strings.TrimSuffix(name, filepath.Ext(name))}
+		_, file.crdErr = fmt.Sscanf(file.regionName, "r.%d.%d", &file.crd.X, &file.crd.Z)
+		if file.crdErr == nil && !area.containsRegion(file.crd.X, file.crd.Z) {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// listRegionFiles returns the region files within src which are within the area
+func listRegionFiles(src string, area *RenderArea) ([]regionFile, error) {
 	entries, err := os.ReadDir(src)
 	if err != nil {
 		return nil, err
 	}
 
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return regionFiles(names, area), nil
+}
+
+// regionJob is a region file to render along with the chunks which are redrawn
+// because a chunk around them changed, region files without coordinates are
+// never finished
+type regionJob struct {
+	regionFile
+
+	redraw map[coord]struct{}
+}
+
+// regionTimestamps returns the timestamp of every chunk within a region which
+// exists and is within the area, zero for the others
+func regionTimestamps(reg *region.Region, inArea func(x, z int) bool) []int32 {
+	timestamps := make([]int32, 32*32)
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			if reg.ExistSector(x, z) && inArea(x, z) {
+				timestamps[chunkTimestampIndex(x, z)] = reg.Timestamps[z][x]
+			}
+		}
+	}
+	return timestamps
+}
+
+// planRedraw sets the chunks each region redraws because a chunk within margin
+// blocks of them changed. Regions which only need redrawing because of a
+// change in a neighbor are added to the returned jobs.
+func (r *Renderer) planRedraw(src, regionDst string, jobs []*regionJob, opts WorldRenderOpts, margin int) []*regionJob {
+	ring := (margin + 15) / 16
+
+	redraw := make(map[coord]map[coord]struct{})
+	for _, job := range jobs {
+		if job.crdErr != nil {
+			continue
+		}
+
+		reg, err := region.Open(filepath.Join(src, job.name))
+		if err != nil {
+			continue
+		}
+
+		inArea := func(x, z int) bool {
+			return opts.Area.containsChunk(job.crd.X*32+x, job.crd.Z*32+z)
+		}
+		timestamps := regionTimestamps(reg, inArea)
+
+		// regions without a previous image are drawn from scratch
+		previous := opts.previousChunkTimestamps(job.regionName, reg)
+		if _, err := os.Stat(filepath.Join(regionDst, job.regionName+".png")); err != nil {
+			previous = nil
+		}
+		reg.Close()
+
+		for x := 0; x < 32; x++ {
+			for z := 0; z < 32; z++ {
+				idx := chunkTimestampIndex(x, z)
+				if previous != nil && timestamps[idx] == previous[idx] {
+					continue
+				} else if previous == nil && timestamps[idx] == 0 {
+					continue
+				}
+
+				for dx := -ring; dx <= ring; dx++ {
+					for dz := -ring; dz <= ring; dz++ {
+						chunkX, chunkZ := job.crd.X*32+x+dx, job.crd.Z*32+z+dz
+						regionCrd := coord{X: int(math.Floor(float64(chunkX) / 32.0)), Z: int(math.Floor(float64(chunkZ) / 32.0))}
+						if redraw[regionCrd] == nil {
+							redraw[regionCrd] = make(map[coord]struct{})
+						}
+						redraw[regionCrd][coord{X: chunkX - regionCrd.X*32, Z: chunkZ - regionCrd.Z*32}] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	listed := make(map[coord]bool)
+	for _, job := range jobs {
+		if job.crdErr == nil {
+			job.redraw = redraw[job.crd]
+			listed[job.crd] = true
+		}
+	}
+
+	// sort the added regions so they are rendered in a stable order
+	added := []coord{}
+	for crd := range redraw {
+		if !listed[crd] && opts.Area.containsRegion(crd.X, crd.Z) {
+			added = append(added, crd)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].X < added[j].X || (added[i].X == added[j].X && added[i].Z < added[j].Z)
+	})
+
+	for _, crd := range added {
+		name := fmt.Sprintf("r.%d.%d.mca", crd.X, crd.Z)
+		if _, err := os.Stat(filepath.Join(src, name)); err != nil {
+			continue
+		}
+		jobs = append(jobs, &regionJob{
+			regionFile: regionFile{name: name, regionName: strings.TrimSuffix(name, ".mca"), crd: crd},
+			redraw:     redraw[crd],
+		})
+	}
+	return jobs
+}
+
+// RenderRegionFiles renders only the named region files within src, the
+// result only contains chunk timestamps for those regions and the neighbors
+// which were redrawn along with them
+func (r *Renderer) RenderRegionFiles(src, dst string, names []string, opts WorldRenderOpts) (*WorldRenderResult, error) {
+	return r.renderRegionFiles(src, dst, regionFiles(names, opts.Area), opts)
+}
+
+func (r *Renderer) renderRegionFiles(src, dst string, files []regionFile, opts WorldRenderOpts) (*WorldRenderResult, error) {
+	var err error
+
 	var renderedChunks atomic.Uint32
+	var skippedChunks atomic.Uint32
+
+	var updatedLock sync.Mutex
+	updatedRegions := make(map[coord]struct{})
 
 	result := WorldRenderResult{
-		RegionTimestamps: make(map[string]int32),
+		ChunkTimestamps: make(map[string][]int32),
 	}
 
 	concurrency := opts.Concurrency
@@ -47,65 +230,153 @@ func (r *Renderer) RenderWorld(src, dst string, opts WorldRenderOpts) (*WorldRen
 	}
 	guard := make(chan struct{}, concurrency)
 
-	var wg sync.WaitGroup
-	for _, e := range entries {
-		reg, err := region.Open(filepath.Join(src, e.Name()))
-		if errors.Is(err, io.EOF) {
-			continue
+	// renderers with their own layout write region images to a separate
+	// directory which is later stitched into the final tiles
+	regionDst := dst
+	layout, hasLayout := r.chunk.(RegionLayout)
+	if hasLayout {
+		regionDst = filepath.Join(dst, "regions")
+		err = os.MkdirAll(regionDst, os.ModePerm)
+		if err != nil {
+			return nil, err
 		}
+	}
 
+	// regions left by builds with a larger area are removed along with their
+	// tiles
+	if opts.Area != nil {
+		removed, err := removeRegionsOutside(dst, regionDst, opts.Area)
 		if err != nil {
-			log.Printf("[renderer] failed to open region file %s: %v", filepath.Join(src, e.Name()), err)
-			continue
+			return nil, err
+		}
+		for crd := range removed {
+			updatedRegions[crd] = struct{}{}
+		}
+	}
+
+	// renderers merge their legend with the previous one so entries from
+	// regions which were not rendered this time are kept
+	if legendFile := GetLegendFile(r.chunk); opts.Clean && legendFile != "" {
+		err = os.Remove(filepath.Join(dst, legendFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
 		}
+	}
+
+	jobs := []*regionJob{}
+	for _, file := range files {
+		jobs = append(jobs, &regionJob{regionFile: file})
+	}
+
+	// region images are held until every region around them has been stored,
+	// they are then finished and written once
+	var finish *finishQueue
+	finisher, finishing := r.chunk.(RegionFinisher)
+	if finishing && finisher.FinishMargin() > 0 {
+		jobs = r.planRedraw(src, regionDst, jobs, opts, finisher.FinishMargin())
+		finish = newFinishQueue(jobs, finisher.FinishMargin())
+
+		// rendering in order lets regions be finished soon after the next
+		// column of regions is stored
+		sort.SliceStable(jobs, func(i, j int) bool {
+			a, b := jobs[i].crd, jobs[j].crd
+			if a.X != b.X {
+				return a.X < b.X
+			}
+			return a.Z < b.Z
+		})
+	} else {
+		finishing = false
+	}
 
+	var wg sync.WaitGroup
+	for _, job := range jobs {
 		guard <- struct{}{}
 		wg.Add(1)
-		go func(name string, reg *region.Region) {
+		go func(job *regionJob) {
 			defer wg.Done()
-			defer reg.Close()
 			defer func() {
 				<-guard
 			}()
 
-			regionName := strings.TrimSuffix(name, filepath.Ext(name))
+			// regions which were not stored must not hold back their neighbors
+			var held *finishingRegion
+			if finishing && job.crdErr == nil {
+				defer func() {
+					for _, ready := range finish.stored(job.crd, held) {
+						err := r.finishRegion(finisher, dst, ready)
+						if err != nil {
+							log.Printf("[renderer] failed to write region image %s: %v", ready.path, err)
+							continue
+						}
+
+						updatedLock.Lock()
+						updatedRegions[ready.crd] = struct{}{}
+						updatedLock.Unlock()
+					}
+				}()
+			}
 
-			var previousMaxTimestamp int32
-			if opts.RegionTimestamps != nil {
-				previousMaxTimestamp = opts.RegionTimestamps[regionName]
+			reg, err := region.Open(filepath.Join(src, job.name))
+			if errors.Is(err, io.EOF) {
+				return
 			}
 
-			img, maxTimestamp, renderedChunkCount, err := r.renderRegion(reg, previousMaxTimestamp)
-			renderedChunks.Add(renderedChunkCount)
 			if err != nil {
-				log.Printf("[renderer] failed to render region file %s: %v", filepath.Join(src, name), err)
+				log.Printf("[renderer] failed to open region file %s: %v", filepath.Join(src, job.name), err)
 				return
 			}
+			defer reg.Close()
+
+			regionImagePath := filepath.Join(regionDst, job.regionName+".png")
+
+			previousTimestamps := opts.previousChunkTimestamps(job.regionName, reg)
+
+			inArea := func(x, z int) bool {
+				return job.crdErr != nil || opts.Area.containsChunk(job.crd.X*32+x, job.crd.Z*32+z)
+			}
 
-			if img == nil {
-				result.Lock()
-				result.RegionTimestamps[regionName] = previousMaxTimestamp
-				result.Unlock()
+			regionResult, err := r.renderRegion(reg, previousTimestamps, regionImagePath, inArea, job.redraw)
+			renderedChunks.Add(regionResult.RenderedChunks)
+			skippedChunks.Add(regionResult.SkippedChunks)
+			if err != nil {
+				log.Printf("[renderer] failed to render region file %s: %v", filepath.Join(src, job.name), err)
 				return
 			}
 
 			result.Lock()
-			result.RegionTimestamps[regionName] = maxTimestamp
+			result.ChunkTimestamps[job.regionName] = regionResult.Timestamps
 			result.Unlock()
 
-			regionImageName := regionName + ".png"
+			if regionResult.Image == nil {
+				return
+			}
+
+			if finishing && job.crdErr == nil {
+				err = finisher.StoreRegion(dst, job.crd.X, job.crd.Z, regionResult.Drawn)
+				if err != nil {
+					log.Printf("[renderer] failed to store region %s: %v", filepath.Join(src, job.name), err)
+					return
+				}
+
+				held = &finishingRegion{crd: job.crd, path: regionImagePath, img: regionResult.Image, drawn: regionResult.Drawn}
+				return
+			}
 
-			f, err := os.Create(filepath.Join(dst, regionImageName))
+			err = writeRegionImage(regionImagePath, regionResult.Image)
 			if err != nil {
-				log.Printf("[renderer] failed to render region file %s: %v", filepath.Join(src, name), err)
+				log.Printf("[renderer] failed to write region image %s: %v", regionImagePath, err)
 				return
 			}
-			defer f.Close()
 
-			if err := png.Encode(f, img); err != nil {
-				log.Printf("[renderer] failed to encode region png %s: %v", filepath.Join(dst, regionImageName), err)
+			if job.crdErr != nil {
+				return
 			}
-		}(e.Name(), reg)
+
+			updatedLock.Lock()
+			updatedRegions[job.crd] = struct{}{}
+			updatedLock.Unlock()
+		}(job)
 	}
 	wg.Wait()
 
@@ -114,70 +385,327 @@ func (r *Renderer) RenderWorld(src, dst string, opts WorldRenderOpts) (*WorldRen
 		return nil, err
 	}
 
+	if hasLayout {
+		if opts.RebuildTiles {
+			updatedRegions, err = listRegionImages(regionDst)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		updatedRegions, err = stitchLayoutTiles(layout, regionDst, dst, updatedRegions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ZoomLevels > 0 {
+		if opts.RebuildTiles && !hasLayout {
+			updatedRegions, err = listRegionImages(dst)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = renderTilePyramid(dst, updatedRegions, opts.ZoomLevels, concurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	result.RenderedChunks = renderedChunks.Load()
+	result.SkippedChunks = skippedChunks.Load()
 
 	return &result, nil
 }
 
+// removeRegionsOutside removes the images and stored columns of regions
+// entirely outside of the area, returning the regions which were removed
+func removeRegionsOutside(dst, regionDst string, area *RenderArea) (map[coord]struct{}, error) {
+	images, err := listRegionImages(regionDst)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[coord]struct{})
+	for crd := range images {
+		if area.containsRegion(crd.X, crd.Z) {
+			continue
+		}
+
+		for _, path := range []string{
+			filepath.Join(regionDst, fmt.Sprintf("r.%d.%d.png", crd.X, crd.Z)),
+			regionColumnsPath(dst, crd.X, crd.Z),
+		} {
+			err := os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		removed[crd] = struct{}{}
+	}
+	return removed, nil
+}
+
+// finishingRegion is a rendered region image waiting to be finished
+type finishingRegion struct {
+	crd   coord
+	path  string
+	img   draw.Image
+	drawn []coord
+}
+
+// finishQueue tracks which regions of a pass have been stored, holding the
+// images of regions until every region within the finish margin is stored
+type finishQueue struct {
+	sync.Mutex
+
+	// ring is how many regions around a region its finish depends on
+	ring int
+
+	pending map[coord]struct{}
+	waiting map[coord]*finishingRegion
+}
+
+func newFinishQueue(jobs []*regionJob, margin int) *finishQueue {
+	q := &finishQueue{
+		ring:    (margin + 511) / 512,
+		pending: make(map[coord]struct{}),
+		waiting: make(map[coord]*finishingRegion),
+	}
+	for _, job := range jobs {
+		if job.crdErr == nil {
+			q.pending[job.crd] = struct{}{}
+		}
+	}
+	return q
+}
+
+// stored marks a region as stored along with its image, which is nil when
+// there is nothing to finish, returning the regions which can now be finished
+func (q *finishQueue) stored(crd coord, held *finishingRegion) []*finishingRegion {
+	q.Lock()
+	defer q.Unlock()
+
+	delete(q.pending, crd)
+	if held != nil {
+		q.waiting[crd] = held
+	}
+
+	ready := []*finishingRegion{}
+	for x := crd.X - q.ring; x <= crd.X+q.ring; x++ {
+		for z := crd.Z - q.ring; z <= crd.Z+q.ring; z++ {
+			region, ok := q.waiting[coord{X: x, Z: z}]
+			if !ok || q.waitsOn(region.crd) {
+				continue
+			}
+			delete(q.waiting, region.crd)
+			ready = append(ready, region)
+		}
+	}
+	return ready
+}
+
+// waitsOn reports whether any region around crd has not been stored yet
+func (q *finishQueue) waitsOn(crd coord) bool {
+	for x := crd.X - q.ring; x <= crd.X+q.ring; x++ {
+		for z := crd.Z - q.ring; z <= crd.Z+q.ring; z++ {
+			if _, ok := q.pending[coord{X: x, Z: z}]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// finishRegion finishes the chunks drawn in a region image and writes it, the
+// unfinished image is written when finishing fails
+func (r *Renderer) finishRegion(finisher RegionFinisher, dst string, region *finishingRegion) error {
+	err := finisher.FinishRegion(dst, region.crd.X, region.crd.Z, region.img, region.drawn)
+	if err != nil {
+		log.Printf("[renderer] failed to finish region %s: %v", region.path, err)
+	}
+	return writeRegionImage(region.path, region.img)
+}
+
+// writeRegionImage encodes a region image as a png
+func writeRegionImage(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, img)
+}
+
 type chunkImageResult struct {
-	Timestamp int32
-	X         int
-	Z         int
-	Image     image.Image
-	Error     error
+	X     int
+	Z     int
+	Image image.Image
+	Error error
+}
+
+type regionRenderResult struct {
+	// Image is nil when no chunks in the region required rendering
+	Image draw.Image
+
+	// Drawn are the chunks whose part of the image was drawn or cleared
+	Drawn []coord
+
+	Timestamps     []int32
+	RenderedChunks uint32
+	SkippedChunks  uint32
 }
 
-func (r *Renderer) renderRegion(reg *region.Region, previousMaxTimestamp int32) (image.Image, int32, uint32, error) {
+// renderRegion renders every chunk in a region whose timestamp differs from
+// previousTimestamps or which is in redraw, drawing them over the existing
+// region image if one exists. Chunks for which inArea returns false are
+// treated as if they do not exist.
+func (r *Renderer) renderRegion(reg *region.Region, previousTimestamps []int32, previousImagePath string, inArea func(x, z int) bool, redraw map[coord]struct{}) (regionRenderResult, error) {
 	chunkImageHeight, chunkImageWidth := r.chunk.ImageSize()
 	regionImageHeight := chunkImageHeight * 32
 	regionImageWidth := chunkImageWidth * 32
 
-	img := image.NewRGBA64(image.Rect(0, 0, regionImageHeight, regionImageWidth))
+	// renderers with their own layout may have overlapping chunk images, so
+	// we always redraw the whole region for them
+	layout, hasLayout := r.chunk.(RegionLayout)
+	if hasLayout {
+		regionImageHeight, regionImageWidth = layout.RegionImageSize()
+	}
 
-	chunkImages := make(chan chunkImageResult)
+	result := regionRenderResult{
+		Timestamps: regionTimestamps(reg, inArea),
+	}
 
-	needRender := false
-	for x := 0; x < 32; x++ {
-		for z := 0; z < 32; z++ {
-			chunkTimestamp := reg.Timestamps[z][x]
-			if chunkTimestamp > previousMaxTimestamp {
-				needRender = true
-			}
+	needRender := previousTimestamps == nil || len(redraw) > 0
+	for idx, ts := range result.Timestamps {
+		if previousTimestamps != nil && ts != previousTimestamps[idx] {
+			needRender = true
 		}
 	}
 
 	if !needRender {
-		return nil, 0, 0, nil
+		for _, ts := range result.Timestamps {
+			if ts != 0 {
+				result.SkippedChunks += 1
+			}
+		}
+		return result, nil
+	}
+
+	var img draw.Image = image.NewRGBA64(image.Rect(0, 0, regionImageHeight, regionImageWidth))
+	if hasLayout {
+		img = image.NewRGBA(image.Rect(0, 0, regionImageHeight, regionImageWidth))
+	}
+
+	// when only some chunks changed we draw them over the previously rendered image
+	if previousTimestamps != nil {
+		if hasLayout || !loadRegionImage(img, previousImagePath) {
+			previousTimestamps = nil
+		}
+	}
+
+	// renderers with a layout draw overlapping chunk images back-to-front, they
+	// are rendered one diagonal at a time so only the chunk images of a single
+	// diagonal are held before they are drawn
+	batches := [][]coord{{}}
+	if hasLayout {
+		batches = make([][]coord, 63)
 	}
 
-	var wg sync.WaitGroup
 	for x := 0; x < 32; x++ {
 		for z := 0; z < 32; z++ {
-			sector, err := reg.ReadSector(x, z)
-			if errors.Is(err, region.ErrNoSector) {
+			chunkTimestamp := result.Timestamps[chunkTimestampIndex(x, z)]
+			_, neighborChanged := redraw[coord{X: x, Z: z}]
+			if previousTimestamps != nil && chunkTimestamp == previousTimestamps[chunkTimestampIndex(x, z)] && !neighborChanged {
+				if chunkTimestamp != 0 {
+					result.SkippedChunks += 1
+				}
+				continue
+			}
+
+			// clear out whatever was previously drawn for this chunk
+			if previousTimestamps != nil {
+				result.Drawn = append(result.Drawn, coord{X: x, Z: z})
+				chunkBounds := image.Rect(0, 0, chunkImageHeight, chunkImageWidth).Add(image.Point{
+					x * chunkImageHeight,
+					z * chunkImageWidth,
+				})
+				draw.Draw(img, chunkBounds, image.Transparent, image.Point{0, 0}, draw.Src)
+			}
+
+			if !inArea(x, z) {
 				continue
 			}
 
-			if len(sector) == 0 {
-				return nil, 0, 0, fmt.Errorf("sector is out of bounds")
+			if hasLayout {
+				batches[x+z] = append(batches[x+z], coord{X: x, Z: z})
+			} else {
+				batches[0] = append(batches[0], coord{X: x, Z: z})
 			}
+		}
+	}
 
-			chunkTimestamp := reg.Timestamps[z][x]
+	for _, batch := range batches {
+		chunkImages, err := r.renderChunks(reg, batch)
+		if err != nil {
+			return result, err
+		}
 
-			wg.Add(1)
-			go func(x, z int) {
-				defer wg.Done()
+		for _, chunkImage := range chunkImages {
+			result.RenderedChunks += 1
+			if previousTimestamps == nil {
+				result.Drawn = append(result.Drawn, coord{X: chunkImage.X, Z: chunkImage.Z})
+			}
 
-				image, err := r.renderSector(sector)
-				chunkImages <- chunkImageResult{
-					Timestamp: chunkTimestamp,
-					X:         x,
-					Z:         z,
-					Image:     image,
-					Error:     err,
-				}
-			}(x, z)
+			if hasLayout {
+				continue
+			}
+
+			draw.Draw(img, chunkImage.Image.Bounds().Add(image.Point{
+				chunkImage.X * chunkImageHeight,
+				chunkImage.Z * chunkImageWidth,
+			}), chunkImage.Image, image.Point{0, 0}, draw.Src)
+		}
+
+		if hasLayout {
+			drawLayoutChunkImages(img, layout, chunkImages)
+		}
+	}
+
+	result.Image = img
+	return result, nil
+}
+
+// renderChunks renders chunks of a region concurrently, chunks which do not
+// exist or have nothing to draw are left out of the result
+func (r *Renderer) renderChunks(reg *region.Region, chunks []coord) ([]chunkImageResult, error) {
+	chunkImages := make(chan chunkImageResult, len(chunks))
+
+	var wg sync.WaitGroup
+	for _, crd := range chunks {
+		sector, err := reg.ReadSector(crd.X, crd.Z)
+		if errors.Is(err, region.ErrNoSector) {
+			continue
+		}
+
+		if len(sector) == 0 {
+			return nil, fmt.Errorf("sector is out of bounds")
 		}
+
+		wg.Add(1)
+		go func(x, z int) {
+			defer wg.Done()
+
+			image, err := r.renderSector(sector)
+			chunkImages <- chunkImageResult{
+				X:     x,
+				Z:     z,
+				Image: image,
+				Error: err,
+			}
+		}(crd.X, crd.Z)
 	}
 
 	go func() {
@@ -185,29 +713,70 @@ func (r *Renderer) renderRegion(reg *region.Region, previousMaxTimestamp int32)
 		close(chunkImages)
 	}()
 
-	var maxTimestamp int32
-	chunkCount := uint32(0)
+	var renderErr error
+	result := []chunkImageResult{}
 	for chunkImage := range chunkImages {
 		if chunkImage.Error != nil {
-			return nil, 0, 0, chunkImage.Error
+			if renderErr == nil {
+				renderErr = chunkImage.Error
+			}
+			continue
 		}
 
-		if chunkImage.Image == nil {
-			continue
+		if chunkImage.Image != nil {
+			result = append(result, chunkImage)
 		}
+	}
+	return result, renderErr
+}
 
-		if chunkImage.Timestamp > maxTimestamp {
-			maxTimestamp = chunkImage.Timestamp
+// drawLayoutChunkImages composites chunk images using a renderers layout, the
+// images are drawn back-to-front so closer chunks overlap those behind them
+func drawLayoutChunkImages(img draw.Image, layout RegionLayout, chunkImages []chunkImageResult) {
+	sort.Slice(chunkImages, func(i, j int) bool {
+		a, b := chunkImages[i], chunkImages[j]
+		if a.X+a.Z != b.X+b.Z {
+			return a.X+a.Z < b.X+b.Z
 		}
+		return a.X < b.X
+	})
+
+	// chunk images may only cover part of their full size, their bounds are
+	// relative to the top left of the full chunk image
+	for _, chunkImage := range chunkImages {
+		offset := layout.ChunkImageOffset(chunkImage.X, chunkImage.Z)
+		bounds := chunkImage.Image.Bounds()
+		draw.Draw(img, bounds.Add(offset), chunkImage.Image, bounds.Min, draw.Over)
+	}
+}
+
+// loadRegionImage draws a previously rendered region image into img, returning
+// false if the image does not exist or could not be read
+func loadRegionImage(img draw.Image, path string) bool {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
 
-		chunkCount += 1
-		draw.Draw(img, chunkImage.Image.Bounds().Add(image.Point{
-			chunkImage.X * chunkImageHeight,
-			chunkImage.Z * chunkImageWidth,
-		}), chunkImage.Image, image.Point{0, 0}, draw.Src)
+	src, err := png.Decode(fd)
+	if err != nil {
+		log.Printf("[renderer] failed to decode previous region image %s, rendering all chunks: %v", path, err)
+		return false
 	}
 
-	return img, maxTimestamp, chunkCount, nil
+	if src.Bounds() != img.Bounds() {
+		return false
+	}
+
+	draw.Draw(img, img.Bounds(), src, image.Point{0, 0}, draw.Src)
+	return true
+}
+
+// chunkTimestampIndex returns the index into a regions chunk timestamp list for
+// the chunk at x, z
+func chunkTimestampIndex(x, z int) int {
+	return (z * 32) + x
 }
 
 func (r *Renderer) renderSector(sector []byte) (image.Image, error) {
@@ -217,10 +786,7 @@ func (r *Renderer) renderSector(sector []byte) (image.Image, error) {
 		return nil, err
 	}
 
-	if chunk.Status != "minecraft:full" &&
-		chunk.Status != "minecraft:spawn" &&
-		chunk.Status != "minecraft:postprocessed" &&
-		chunk.Status != "minecraft:fullchunk" {
+	if !isChunkComplete(&chunk) {
 		return nil, nil
 	}
 
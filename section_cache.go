@@ -1,6 +1,8 @@
 package carto
 
 import (
+	"math/bits"
+
 	"github.com/Tnze/go-mc/level"
 	"github.com/Tnze/go-mc/save"
 )
@@ -9,6 +11,13 @@ type sectionCache struct {
 	palette *Palette
 	chunk   *save.Chunk
 	cache   map[int]*sectionCacheItem
+
+	// sections maps a sections Y value to its index in chunk.Sections
+	sections map[int]int
+
+	// minY and maxY are the lowest and highest block y covered by sections with block data
+	minY int
+	maxY int
 }
 
 type sectionCacheItem struct {
@@ -17,38 +26,137 @@ type sectionCacheItem struct {
 	biomes  *level.BitStorage
 }
 
+// newSectionCache creates a cache of decoded sections for a chunk, palette may
+// be nil for renderers which do not need block colors
 func newSectionCache(palette *Palette, chunk *save.Chunk) *sectionCache {
-	return &sectionCache{
-		palette: palette,
-		chunk:   chunk,
-		cache:   make(map[int]*sectionCacheItem),
+	c := &sectionCache{
+		palette:  palette,
+		chunk:    chunk,
+		cache:    make(map[int]*sectionCacheItem),
+		sections: make(map[int]int),
+		minY:     chunkMinY(chunk),
+		maxY:     chunkMinY(chunk) - 1,
+	}
+
+	for idx, section := range chunk.Sections {
+		c.sections[int(section.Y)] = idx
+
+		// sections outside of the world only hold lighting data
+		if len(section.BlockStates.Palette) == 0 {
+			continue
+		}
+
+		if top := (int(section.Y)+1)*16 - 1; top > c.maxY {
+			c.maxY = top
+		}
 	}
+
+	return c
 }
 
-func (c *sectionCache) get(index int) *sectionCacheItem {
-	sc, ok := c.cache[index]
+// get returns the section with the given Y value, or nil if the chunk has none
+func (c *sectionCache) get(sectionY int) *sectionCacheItem {
+	sc, ok := c.cache[sectionY]
 	if !ok {
-		if len(c.chunk.Sections) <= index {
+		index, ok := c.sections[sectionY]
+		if !ok {
 			return nil
 		}
 
 		section := c.chunk.Sections[index]
 
 		// prepare the palette for this section so we can lookup metadata for blockstates
-		c.palette.Prepare(section)
+		if c.palette != nil {
+			c.palette.Prepare(section)
+		}
 
 		v := calcBitsPerValue(16*16*16, len(section.BlockStates.Data))
 		storage := level.NewBitStorage(v, 16*16*16, section.BlockStates.Data)
 
-		v = calcBitsPerValue(16*16*16, len(section.Biomes.Data))
-		biomes := level.NewBitStorage(v, 16*16*16, section.Biomes.Data)
+		biomes := newBiomeStorage(section)
 		sc = &sectionCacheItem{
 			section: section,
 			storage: storage,
 			biomes:  biomes,
 		}
 
-		c.cache[index] = sc
+		c.cache[sectionY] = sc
 	}
 	return sc
 }
+
+// newBiomeStorage decodes the 4x4x4 biome grid of a section. Sections with a
+// single biome have no data and every cell reads as index zero.
+func newBiomeStorage(section save.Section) *level.BitStorage {
+	data := section.Biomes.Data
+	if len(data) == 0 || len(section.Biomes.Palette) <= 1 {
+		return level.NewBitStorage(0, 4*4*4, nil)
+	}
+
+	// entries use just enough bits to index the palette
+	v := bits.Len(uint(len(section.Biomes.Palette) - 1))
+	valuesPerLong := 64 / v
+	if (4*4*4+valuesPerLong-1)/valuesPerLong != len(data) {
+		v = calcBitsPerValue(4*4*4, len(data))
+	}
+	return level.NewBitStorage(v, 4*4*4, data)
+}
+
+// biome returns the biome at a block within the section, x, y and z may be
+// world or section relative coordinates
+func (sc *sectionCacheItem) biome(x, y, z int) save.BiomeState {
+	palette := sc.section.Biomes.Palette
+	if len(palette) == 0 {
+		return ""
+	}
+
+	index := sc.biomes.Get(biomeIndex(x, y, z))
+	if index >= len(palette) {
+		return palette[0]
+	}
+	return palette[index]
+}
+
+// getBlock returns the section containing the block at world height y
+func (c *sectionCache) getBlock(y int) *sectionCacheItem {
+	return c.get(blockSectionY(y))
+}
+
+// blockSectionY returns the Y value of the section containing world height y
+func blockSectionY(y int) int {
+	return y >> 4
+}
+
+// blockIndex returns the index of a block within its section
+func blockIndex(x, y, z int) int {
+	return ((((y & 15) * 16) + z) * 16) + x
+}
+
+// biomeIndex returns the index of the 4x4x4 biome cell containing a block
+func biomeIndex(x, y, z int) int {
+	return ((((y&15)>>2)*4)+((z&15)>>2))*4 + ((x & 15) >> 2)
+}
+
+// chunkMinY returns the lowest block y of a chunk, chunks from before 1.18 do
+// not store yPos and always start at zero
+func chunkMinY(chunk *save.Chunk) int {
+	return int(chunk.YPos) * 16
+}
+
+// chunkHeightmap decodes a heightmap from a chunk, values are relative to chunkMinY
+func chunkHeightmap(chunk *save.Chunk, name string) *level.BitStorage {
+	data := chunk.Heightmaps[name]
+
+	bitsForHeight := calcBitsPerValue(16*16, len(data))
+	if bitsForHeight == 0 {
+		return newChunkHeightmap(chunk)
+	}
+
+	return level.NewBitStorage(bitsForHeight, 16*16, data)
+}
+
+// newChunkHeightmap creates an empty heightmap large enough to hold any height in a chunk
+func newChunkHeightmap(chunk *save.Chunk) *level.BitStorage {
+	bitsForHeight := bits.Len(uint(len(chunk.Sections))*16 + 1)
+	return level.NewBitStorage(bitsForHeight, 16*16, nil)
+}
@@ -0,0 +1,344 @@
+package carto
+
+import (
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tnze/go-mc/level"
+	"github.com/Tnze/go-mc/save"
+)
+
+// testChunk builds a chunk with every column filled the same way, layers maps
+// world y levels to block names and unlisted levels are air. Sections are
+// created from minSection up to and including maxSection.
+func testChunk(yPos int32, minSection, maxSection int, layers map[int]string, biome save.BiomeState) *save.Chunk {
+	chunk := &save.Chunk{Status: "minecraft:full", YPos: yPos}
+	minY := int(yPos) * 16
+
+	// heights are the first free block above the top layer, relative to minY
+	surface, floor := 0, 0
+	for y, name := range layers {
+		surface = max(surface, y+1-minY)
+		if name != "minecraft:water" {
+			floor = max(floor, y+1-minY)
+		}
+	}
+
+	for sectionY := minSection; sectionY <= maxSection; sectionY++ {
+		section := save.Section{Y: int8(sectionY)}
+
+		// chunks store a lighting only section below the world
+		if sectionY*16 < minY {
+			chunk.Sections = append(chunk.Sections, section)
+			continue
+		}
+
+		palette := []save.BlockState{{Name: "minecraft:air"}}
+		ids := map[string]int{"minecraft:air": 0}
+		storage := level.NewBitStorage(4, 16*16*16, nil)
+		for y := 0; y < 16; y++ {
+			name, ok := layers[sectionY*16+y]
+			if !ok {
+				continue
+			}
+
+			id, ok := ids[name]
+			if !ok {
+				id = len(palette)
+				ids[name] = id
+				palette = append(palette, save.BlockState{Name: name})
+			}
+			for idx := 0; idx < 256; idx++ {
+				storage.Set(y*256+idx, id)
+			}
+		}
+
+		section.BlockStates.Palette = palette
+		if len(palette) > 1 {
+			section.BlockStates.Data = storage.Raw()
+		}
+		section.Biomes.Palette = []save.BiomeState{biome}
+		chunk.Sections = append(chunk.Sections, section)
+	}
+
+	worldHeight := (maxSection+1)*16 - minY
+	chunk.Heightmaps = map[string][]uint64{
+		"MOTION_BLOCKING": testHeightmap(worldHeight, surface),
+		"OCEAN_FLOOR":     testHeightmap(worldHeight, floor),
+	}
+	return chunk
+}
+
+// testHeightmap packs a heightmap with the same height in every column
+func testHeightmap(worldHeight, height int) []uint64 {
+	storage := level.NewBitStorage(bits.Len(uint(worldHeight+1)), 16*16, nil)
+	for idx := 0; idx < 256; idx++ {
+		storage.Set(idx, height)
+	}
+	return storage.Raw()
+}
+
+func TestChunkHeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunk  *save.Chunk
+		minY   int
+		maxY   int
+		height int
+	}{
+		{
+			name:   "world from y 0",
+			chunk:  testChunk(0, -1, 15, map[int]string{0: "minecraft:bedrock", 62: "minecraft:stone"}, "minecraft:plains"),
+			minY:   0,
+			maxY:   255,
+			height: 63,
+		},
+		{
+			name:   "1.20",
+			chunk:  testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", -20: "minecraft:deepslate", 62: "minecraft:stone"}, "minecraft:plains"),
+			minY:   -64,
+			maxY:   319,
+			height: 127,
+		},
+		{
+			name:   "1.20 below zero",
+			chunk:  testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock", -20: "minecraft:deepslate"}, "minecraft:plains"),
+			minY:   -64,
+			maxY:   319,
+			height: 45,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if minY := chunkMinY(test.chunk); minY != test.minY {
+				t.Errorf("chunkMinY = %d, want %d", minY, test.minY)
+			}
+
+			cache := newSectionCache(nil, test.chunk)
+			if cache.minY != test.minY || cache.maxY != test.maxY {
+				t.Errorf("section cache covers %d to %d, want %d to %d", cache.minY, cache.maxY, test.minY, test.maxY)
+			}
+
+			heights := chunkHeightmap(test.chunk, "MOTION_BLOCKING")
+			for _, idx := range []int{0, 17, 255} {
+				if height := heights.Get(idx); height != test.height {
+					t.Errorf("height of column %d = %d, want %d", idx, height, test.height)
+				}
+			}
+
+			// the top block is one below the heightmap value
+			top := test.height + test.minY - 1
+			sc := cache.getBlock(top)
+			if sc == nil {
+				t.Fatalf("no section for y %d", top)
+			}
+			if block := sc.section.BlockStates.Palette[sc.storage.Get(blockIndex(3, top, 5))]; block.Name == "minecraft:air" {
+				t.Errorf("block at y %d is air", top)
+			}
+		})
+	}
+}
+
+// loadTestChunk decodes a chunk under testdata/chunks, the files hold a region
+// file sector including its compression byte
+func loadTestChunk(t *testing.T, name string) *save.Chunk {
+	data, err := os.ReadFile(filepath.Join("testdata", "chunks", name+".nbt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunk save.Chunk
+	err = chunk.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &chunk
+}
+
+func TestLoadChunk(t *testing.T) {
+	tests := []struct {
+		name   string
+		minY   int
+		maxY   int
+		height int
+		top    string
+
+		// west and east are the biomes of the surface on either side of the chunk
+		west, east save.BiomeState
+	}{
+		{
+			name:   "overworld",
+			minY:   -64,
+			maxY:   319,
+			height: 128,
+			top:    "minecraft:grass_block",
+			west:   "minecraft:plains",
+			east:   "minecraft:swamp",
+		},
+		{
+			name:   "nether",
+			minY:   0,
+			maxY:   255,
+			height: 128,
+			top:    "minecraft:bedrock",
+			west:   "minecraft:nether_wastes",
+			east:   "minecraft:nether_wastes",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunk := loadTestChunk(t, test.name)
+			if !isChunkComplete(chunk) {
+				t.Fatalf("chunk with status %q is not complete", chunk.Status)
+			}
+
+			if minY := chunkMinY(chunk); minY != test.minY {
+				t.Errorf("chunkMinY = %d, want %d", minY, test.minY)
+			}
+
+			cache := newSectionCache(nil, chunk)
+			if cache.minY != test.minY || cache.maxY != test.maxY {
+				t.Errorf("section cache covers %d to %d, want %d to %d", cache.minY, cache.maxY, test.minY, test.maxY)
+			}
+
+			heights := chunkHeightmap(chunk, "MOTION_BLOCKING")
+			top := test.height + test.minY - 1
+			sc := cache.getBlock(top)
+			if sc == nil {
+				t.Fatalf("no section for y %d", top)
+			}
+
+			for _, x := range []int{0, 15} {
+				if height := heights.Get(9*16 + x); height != test.height {
+					t.Errorf("height of column %d, 9 = %d, want %d", x, height, test.height)
+				}
+				if block := sc.section.BlockStates.Palette[sc.storage.Get(blockIndex(x, top, 9))]; block.Name != test.top {
+					t.Errorf("block at %d, %d, 9 = %s, want %s", x, top, block.Name, test.top)
+				}
+			}
+
+			if biome := sc.biome(0, top, 9); biome != test.west {
+				t.Errorf("biome of the west side = %s, want %s", biome, test.west)
+			}
+			if biome := sc.biome(15, top, 9); biome != test.east {
+				t.Errorf("biome of the east side = %s, want %s", biome, test.east)
+			}
+		})
+	}
+}
+
+func TestChunkHeightmapMissing(t *testing.T) {
+	chunk := testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock"}, "minecraft:plains")
+	delete(chunk.Heightmaps, "OCEAN_FLOOR")
+
+	heights := chunkHeightmap(chunk, "OCEAN_FLOOR")
+	if height := heights.Get(0); height != 0 {
+		t.Errorf("missing heightmap has height %d, want 0", height)
+	}
+
+	// the empty heightmap must be able to hold the top of the world
+	heights.Set(0, 384)
+	if height := heights.Get(0); height != 384 {
+		t.Errorf("empty heightmap stores %d as %d", 384, height)
+	}
+}
+
+// setTestBiomes packs the biomes of a section, cell returns the palette index
+// of each 4x4x4 cell and bits is the width entries are packed with
+func setTestBiomes(section *save.Section, palette []save.BiomeState, bits int, cell func(x, y, z int) int) {
+	section.Biomes.Palette = palette
+	section.Biomes.Data = nil
+	if bits == 0 {
+		return
+	}
+
+	storage := level.NewBitStorage(bits, 4*4*4, nil)
+	for y := 0; y < 4; y++ {
+		for z := 0; z < 4; z++ {
+			for x := 0; x < 4; x++ {
+				storage.Set((y*4+z)*4+x, cell(x, y, z))
+			}
+		}
+	}
+	section.Biomes.Data = storage.Raw()
+}
+
+func TestBiomeIndex(t *testing.T) {
+	tests := []struct {
+		x, y, z int
+		want    int
+	}{
+		{0, 0, 0, 0},
+		{3, 3, 3, 0},
+		{4, 0, 0, 1},
+		{15, 0, 0, 3},
+		{0, 0, 4, 4},
+		{0, 0, 15, 12},
+		{0, 4, 0, 16},
+		{0, 15, 0, 48},
+		{15, 15, 15, 63},
+		{5, 9, 13, 2*16 + 3*4 + 1},
+		// world coordinates wrap to the section, including below zero
+		{-1, -64, 17, 3},
+		{20, -1, -12, 3*16 + 1*4 + 1},
+	}
+
+	for _, test := range tests {
+		if got := biomeIndex(test.x, test.y, test.z); got != test.want {
+			t.Errorf("biomeIndex(%d, %d, %d) = %d, want %d", test.x, test.y, test.z, got, test.want)
+		}
+	}
+}
+
+func TestSectionBiome(t *testing.T) {
+	checker := func(x, y, z int) int {
+		return (x + z + y) % 2
+	}
+	stripes := func(x, y, z int) int {
+		return (x + 2*z + 3*y) % 3
+	}
+
+	tests := []struct {
+		name    string
+		palette []save.BiomeState
+		bits    int
+		cell    func(x, y, z int) int
+	}{
+		{"single entry", []save.BiomeState{"minecraft:plains"}, 0, func(x, y, z int) int { return 0 }},
+		{"one bit", []save.BiomeState{"minecraft:plains", "minecraft:swamp"}, 1, checker},
+		{"two bits", []save.BiomeState{"minecraft:plains", "minecraft:swamp", "minecraft:desert"}, 2, stripes},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunk := testChunk(-4, -4, 19, map[int]string{-64: "minecraft:bedrock"}, "minecraft:plains")
+
+			// the section below zero checks world y is mapped into it
+			for idx := range chunk.Sections {
+				if chunk.Sections[idx].Y == -1 {
+					setTestBiomes(&chunk.Sections[idx], test.palette, test.bits, test.cell)
+				}
+			}
+
+			if storage := newBiomeStorage(chunk.Sections[3]); len(storage.Raw()) != len(chunk.Sections[3].Biomes.Data) {
+				t.Errorf("biome storage holds %d longs, want %d", len(storage.Raw()), len(chunk.Sections[3].Biomes.Data))
+			}
+
+			sc := newSectionCache(nil, chunk).getBlock(-16)
+			for y := -16; y < 0; y++ {
+				for z := 0; z < 16; z++ {
+					for x := 0; x < 16; x++ {
+						want := test.palette[test.cell(x/4, (y+16)/4, z/4)]
+						if got := sc.biome(x, y, z); got != want {
+							t.Fatalf("biome at (%d, %d, %d) = %s, want %s", x, y, z, got, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package serve
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/b1naryth1ef/carto"
+	"github.com/b1naryth1ef/carto/build"
+)
+
+type ServeOpts struct {
+	// Listen is the address the http server binds to
+	Listen string
+	// Path is the output directory being served
+	Path string
+	// BasePath is an optional prefix all content is served under
+	BasePath string
+
+	// Watch enables re-rendering regions as they change and notifying open browsers
+	Watch         bool
+	WatchInterval time.Duration
+	WatchDebounce time.Duration
+
+	// Config is rendered while watching, it may be nil otherwise
+	Config *carto.Config
+}
+
+// events fans out reload notifications to all connected browsers
+type events struct {
+	sync.Mutex
+
+	clients map[chan string]struct{}
+}
+
+func newEvents() *events {
+	return &events{
+		clients: make(map[chan string]struct{}),
+	}
+}
+
+func (e *events) subscribe() chan string {
+	e.Lock()
+	defer e.Unlock()
+
+	ch := make(chan string, 1)
+	e.clients[ch] = struct{}{}
+	return ch
+}
+
+func (e *events) unsubscribe(ch chan string) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.clients, ch)
+}
+
+func (e *events) publish(msg string) {
+	e.Lock()
+	defer e.Unlock()
+
+	for ch := range e.clients {
+		// slow clients only need to know about the latest build
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams reload notices to the browser as server-sent events
+func (e *events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := e.subscribe()
+	defer e.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-ch:
+			fmt.Fprintf(w, "event: reload\ndata: %s\n\n", msg)
+			flusher.Flush()
+		}
+	}
+}
+
+// cacheHeaders sets caching headers based on the type of content being served,
+// tiles change in place on rebuilds so they are revalidated unless the url
+// carries the version they were reloaded at
+func cacheHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/tiles/") && strings.HasSuffix(r.URL.Path, ".png") && r.URL.Query().Has("v") {
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func normalizeBasePath(basePath string) string {
+	basePath = path.Clean("/" + basePath)
+	if basePath == "/" {
+		return ""
+	}
+	return basePath
+}
+
+func Serve(opts ServeOpts) error {
+	basePath := normalizeBasePath(opts.BasePath)
+
+	mux := http.NewServeMux()
+
+	events := newEvents()
+	mux.Handle("/_carto/events", events)
+	mux.Handle("/", cacheHeaders(http.FileServer(http.Dir(opts.Path))))
+
+	var handler http.Handler = mux
+	if basePath != "" {
+		root := http.NewServeMux()
+		root.Handle(basePath+"/", http.StripPrefix(basePath, mux))
+		root.Handle(basePath, http.RedirectHandler(basePath+"/", http.StatusMovedPermanently))
+		handler = root
+	}
+
+	if opts.Watch {
+		go watch(opts, events)
+	}
+
+	log.Printf("[serve] serving %s on %s%s/", opts.Path, opts.Listen, basePath)
+	return http.ListenAndServe(opts.Listen, handler)
+}
+
+// watch keeps the build loaded and re-renders regions as they are saved,
+// notifying browsers after each render
+func watch(opts ServeOpts, events *events) {
+	err := build.Watch(opts.Config, build.WatchOpts{
+		Interval: opts.WatchInterval,
+		Debounce: opts.WatchDebounce,
+		OnRender: func() {
+			events.publish(fmt.Sprintf("%d", time.Now().Unix()))
+		},
+	})
+	if err != nil {
+		log.Printf("[serve] watch failed: %v", err)
+	}
+}
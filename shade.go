@@ -5,13 +5,9 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
 	"math"
-	"os"
-	"path/filepath"
-	"sync"
-
-	"github.com/Tnze/go-mc/level"
+	"slices"
+	"strings"
 )
 
 type coord struct {
@@ -19,160 +15,128 @@ type coord struct {
 	Z int
 }
 
-// ChunkPixelShader handles shading for the ChunkPixelRenderer
-type ChunkPixelShader struct {
-	sync.RWMutex
+// ShadeOpts configures the ChunkPixelShader
+type ShadeOpts struct {
+	// Style is relief for a hillshade which lightens slopes facing the light
+	// and darkens those facing away, or flat to only darken the low side of steps
+	Style string
 
-	regions    map[coord]struct{}
-	heightmaps map[coord]*level.BitStorage
-}
+	// Azimuth is the compass direction the light comes from in degrees
+	// clockwise from north, Altitude is its angle above the horizon
+	Azimuth  float64
+	Altitude float64
 
-func NewChunkPixelShader() *ChunkPixelShader {
-	return &ChunkPixelShader{
-		regions:    make(map[coord]struct{}),
-		heightmaps: make(map[coord]*level.BitStorage),
-	}
+	// Strength scales how strongly slopes are lightened or darkened
+	Strength float64
 }
 
-// add tracks a chunks heightmap for later-use in shading
-func (c *ChunkPixelShader) add(x, z int, hm *level.BitStorage) {
-	c.Lock()
-	defer c.Unlock()
+var shadeStyles = []string{"relief", "flat"}
 
-	regionX, regionZ := int(math.Floor(float64(x)/32.0)), int(math.Floor(float64(z)/32.0))
-	c.regions[coord{X: regionX, Z: regionZ}] = struct{}{}
+// ChunkPixelShader handles shading for the ChunkPixelRenderer
+type ChunkPixelShader struct {
+	opts ShadeOpts
 
-	crd := coord{X: x, Z: z}
-	c.heightmaps[crd] = hm
+	// light is the unit vector pointing towards the light in x (east), z
+	// (south) and up order
+	light [3]float64
 }
 
-// get returns the height map for a given chunk
-func (c *ChunkPixelShader) get(crd coord) *level.BitStorage {
-	c.RLock()
-	defer c.RUnlock()
-	return c.heightmaps[crd]
-}
+// heightFunc returns the height of a column relative to the chunk being shaded,
+// columns outside of it may belong to chunks which were never rendered
+type heightFunc func(x, z int) (int, bool)
 
-// Render generates and overlays shading for all regions
-func (c *ChunkPixelShader) Render(path string) error {
-	errors := make(chan error)
-
-	var wg sync.WaitGroup
-	for crd := range c.regions {
-		wg.Add(1)
-		go func(crd coord) {
-			defer wg.Done()
-			err := c.renderRegion(path, crd)
-			if err != nil {
-				errors <- err
-			}
-		}(crd)
+func NewChunkPixelShader(opts ShadeOpts) (*ChunkPixelShader, error) {
+	if !slices.Contains(shadeStyles, opts.Style) {
+		return nil, fmt.Errorf("unknown shading style '%s' (available: %s)", opts.Style, strings.Join(shadeStyles, ", "))
 	}
 
-	go func() {
-		wg.Wait()
-		close(errors)
-	}()
-
-	for err := range errors {
-		return err
-	}
-
-	return nil
+	azimuth := opts.Azimuth * math.Pi / 180
+	altitude := clamp(opts.Altitude, 0, 90) * math.Pi / 180
+	return &ChunkPixelShader{
+		opts: opts,
+		light: [3]float64{
+			math.Sin(azimuth) * math.Cos(altitude),
+			-math.Cos(azimuth) * math.Cos(altitude),
+			math.Sin(altitude),
+		},
+	}, nil
 }
 
-// renderRegion handles rendering, merging, and saving the shading for a region image
-func (c *ChunkPixelShader) renderRegion(path string, crd coord) error {
-	shadeImg := image.NewRGBA64(image.Rect(0, 0, 32*16, 32*16))
-	for x := 0; x < 32; x++ {
-		for z := 0; z < 32; z++ {
-			chunkCrd := coord{X: (crd.X * 32) + x, Z: (crd.Z * 32) + z}
-			hm := c.get(chunkCrd)
-			if hm == nil {
-				continue
-			}
-
-			chunkImg, err := c.renderChunk(chunkCrd)
-			if err != nil {
-				return fmt.Errorf("failed to render region shading for (%v, %v): %v", crd.X, crd.Z, err)
-			}
-			pnt := image.Point{
-				x * 16,
-				z * 16,
-			}
-			draw.Draw(shadeImg, chunkImg.Bounds().Add(pnt), chunkImg, image.Point{0, 0}, draw.Src)
-		}
-	}
+// shadeChunk overlays the shading of a chunk onto img at pnt
+func (c *ChunkPixelShader) shadeChunk(img draw.Image, pnt image.Point, heightAt heightFunc) {
+	chunkImg := c.renderChunk(heightAt)
+	draw.Draw(img, chunkImg.Bounds().Add(pnt), chunkImg, image.Point{0, 0}, draw.Over)
+}
 
-	fd, err := os.Open(filepath.Join(path, fmt.Sprintf("r.%d.%d.png", crd.X, crd.Z)))
-	if err != nil {
-		return fmt.Errorf("failed to open region final image (%v, %v): %v", crd.X, crd.Z, err)
+// slope returns the change in height per block between two columns either side
+// of a column, falling back to the column itself where a neighbor is missing
+func slope(before int, beforeOk bool, height int, after int, afterOk bool) float64 {
+	switch {
+	case beforeOk && afterOk:
+		return float64(after-before) / 2
+	case afterOk:
+		return float64(after - height)
+	case beforeOk:
+		return float64(height - before)
 	}
+	return 0
+}
 
-	srcImg, err := png.Decode(fd)
-	if err != nil {
-		return fmt.Errorf("failed to decode region final image (%v, %v): %v", crd.X, crd.Z, err)
+// renderChunk handles generating a shaded overlay image for a single chunk
+func (c *ChunkPixelShader) renderChunk(heightAt heightFunc) image.Image {
+	if c.opts.Style == "flat" {
+		return c.renderChunkFlat(heightAt)
 	}
-	fd.Close()
-
-	finalImg := image.NewRGBA64(srcImg.Bounds())
 
-	draw.Draw(finalImg, srcImg.Bounds(), srcImg, image.Point{0, 0}, draw.Src)
-	draw.Draw(finalImg, shadeImg.Bounds(), shadeImg, image.Point{0, 0}, draw.Over)
-
-	fd, err = os.Create(filepath.Join(path, fmt.Sprintf("r.%d.%d.png", crd.X, crd.Z)))
-	if err != nil {
-		return fmt.Errorf("failed to open region final image (%v, %v): %v", crd.X, crd.Z, err)
-	}
-	defer fd.Close()
+	img := image.NewRGBA64(image.Rect(0, 0, 16, 16))
+	flat := c.light[2]
 
-	err = png.Encode(fd, finalImg)
-	if err != nil {
-		return fmt.Errorf("failed to encode final image (%v, %v): %v", crd.X, crd.Z, err)
+	for x := 0; x < 16; x++ {
+		for z := 0; z < 16; z++ {
+			height, _ := heightAt(x, z)
+
+			// the surface normal from the difference of the heights around
+			// the column, one block is one pixel wide
+			west, westOk := heightAt(x-1, z)
+			east, eastOk := heightAt(x+1, z)
+			north, northOk := heightAt(x, z-1)
+			south, southOk := heightAt(x, z+1)
+			dx := slope(west, westOk, height, east, eastOk)
+			dz := slope(north, northOk, height, south, southOk)
+
+			length := math.Sqrt(dx*dx + dz*dz + 1)
+			lit := (-dx*c.light[0] - dz*c.light[1] + c.light[2]) / length
+
+			// shade relative to flat ground so level terrain is left untouched
+			d := (lit - flat) * c.opts.Strength
+			a := uint8(clamp(math.Abs(d)*255, 0, 255))
+			if d > 0 {
+				img.Set(x, z, color.NRGBA{R: 255, G: 255, B: 255, A: a})
+			} else {
+				img.Set(x, z, color.NRGBA{A: a})
+			}
+		}
 	}
 
-	return nil
+	return img
 }
 
-// renderChunk handles generating a shaded overlay image for a single chunk
-func (c *ChunkPixelShader) renderChunk(crd coord) (image.Image, error) {
+// renderChunkFlat darkens columns lower than their north or west neighbor
+func (c *ChunkPixelShader) renderChunkFlat(heightAt heightFunc) image.Image {
 	img := image.NewRGBA64(image.Rect(0, 0, 16, 16))
-	hm := c.get(crd)
 
 	for x := 0; x < 16; x++ {
 		for z := 0; z < 16; z++ {
-			var topHeight int
-			var leftHeight int
-
-			heightmapIndex := ((z) * 16) + x
-			height := hm.Get(heightmapIndex)
-
-			// if x == 0 we must fetch the left height from another chunk
-			if x == 0 {
-				leftHeightMap := c.get(coord{X: crd.X - 1, Z: crd.Z})
-				if leftHeightMap != nil {
-					heightmapIndex := ((z) * 16) + 15
-					leftHeight = leftHeightMap.Get(heightmapIndex)
-				} else {
-					leftHeight = height
-				}
-			} else {
-				heightmapIndex := ((z) * 16) + (x - 1)
-				leftHeight = hm.Get(heightmapIndex)
-			}
+			height, _ := heightAt(x, z)
 
-			// if z == 0 we must fetch the top height from another chunk
-			if z == 0 {
-				topHeightMap := c.get(coord{X: crd.X, Z: crd.Z - 1})
-				if topHeightMap != nil {
-					heightmapIndex := ((15) * 16) + x
-					topHeight = topHeightMap.Get(heightmapIndex)
-				} else {
-					topHeight = height
-				}
-			} else {
-				heightmapIndex := ((z - 1) * 16) + x
-				topHeight = hm.Get(heightmapIndex)
+			// columns without a rendered neighbor are treated as level with it
+			leftHeight, ok := heightAt(x-1, z)
+			if !ok {
+				leftHeight = height
+			}
+			topHeight, ok := heightAt(x, z-1)
+			if !ok {
+				topHeight = height
 			}
 
 			var d int
@@ -195,5 +159,5 @@ func (c *ChunkPixelShader) renderChunk(crd coord) (image.Image, error) {
 		}
 	}
 
-	return img, nil
+	return img
 }
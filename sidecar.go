@@ -0,0 +1,288 @@
+package carto
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/Tnze/go-mc/level"
+	"github.com/Tnze/go-mc/save"
+)
+
+// noHeight marks a column without a height in a regionColumns
+const noHeight = math.MinInt16
+
+// regionColumns is the per column data of a rendered region which finishing
+// its chunks and those of its neighbors needs, it is stored next to the layers
+// tiles so incremental builds do not need to re-read every chunk
+type regionColumns struct {
+	// Heights are relative to the bottom of the world in the column's chunk,
+	// 512*512 in row order
+	Heights []int16
+
+	// ChunkMinY is the bottom of the world of each chunk, chunks upgraded
+	// from before 1.18 may start at a different y level than their neighbors
+	ChunkMinY []int16
+
+	// MinY is the bottom of the world for every chunk in columns stored
+	// before it was kept per chunk
+	MinY int
+
+	// BiomeIDs index into Biomes, zero is used for columns without a biome
+	Biomes   []save.BiomeState
+	BiomeIDs []uint16
+
+	// Tints and Depths are what blending needs to re-tint a column, Depths
+	// hold the water depth plus one and zero for columns which are not darkened
+	Tints  []tintKind
+	Depths []uint8
+}
+
+func newRegionColumns() *regionColumns {
+	heights := make([]int16, 512*512)
+	for idx := range heights {
+		heights[idx] = noHeight
+	}
+
+	return &regionColumns{
+		Heights:   heights,
+		ChunkMinY: make([]int16, 32*32),
+		Biomes:    []save.BiomeState{""},
+		BiomeIDs:  make([]uint16, 512*512),
+		Tints:     make([]tintKind, 512*512),
+		Depths:    make([]uint8, 512*512),
+	}
+}
+
+func regionColumnsPath(dst string, x, z int) string {
+	return filepath.Join(dst, "columns", fmt.Sprintf("r.%d.%d.gob", x, z))
+}
+
+// loadRegionColumns reads the columns stored for a region, returning nil if
+// there are none or they cannot be read
+func loadRegionColumns(dst string, x, z int) *regionColumns {
+	fd, err := os.Open(regionColumnsPath(dst, x, z))
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+
+	var columns regionColumns
+	err = gob.NewDecoder(fd).Decode(&columns)
+	if err != nil || len(columns.Heights) != 512*512 || len(columns.BiomeIDs) != 512*512 || len(columns.Biomes) == 0 {
+		return nil
+	}
+
+	// columns stored by the height renderer have no tints
+	if len(columns.Tints) != 512*512 || len(columns.Depths) != 512*512 {
+		columns.Tints = make([]tintKind, 512*512)
+		columns.Depths = make([]uint8, 512*512)
+	}
+
+	if len(columns.ChunkMinY) != 32*32 {
+		columns.ChunkMinY = make([]int16, 32*32)
+		for idx := range columns.ChunkMinY {
+			columns.ChunkMinY[idx] = int16(columns.MinY)
+		}
+	}
+	return &columns
+}
+
+// save writes the columns of a region, neighboring regions may be reading them
+// concurrently so the file is replaced in one step
+func (c *regionColumns) save(dst string, x, z int) error {
+	path := regionColumnsPath(dst, x, z)
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	err = gob.NewEncoder(fd).Encode(c)
+	fd.Close()
+	if err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// setBiome stores the biome of a column, interning its name
+func (c *regionColumns) setBiome(idx int, biome save.BiomeState) {
+	if biome == "" {
+		c.BiomeIDs[idx] = 0
+		return
+	}
+
+	for id, name := range c.Biomes {
+		if name == biome {
+			c.BiomeIDs[idx] = uint16(id)
+			return
+		}
+	}
+
+	c.BiomeIDs[idx] = uint16(len(c.Biomes))
+	c.Biomes = append(c.Biomes, biome)
+}
+
+// columnIndex returns the index of a column of a chunk within the region, crd
+// is the position of the chunk within the region
+func columnIndex(crd coord, idx int) int {
+	return (crd.Z*16+idx/16)*512 + crd.X*16 + idx%16
+}
+
+// setChunk stores the heights, biomes and tints of a chunk, crd is its
+// position within the region, minY the bottom of its world and biomes and
+// tints may be nil to leave them unchanged
+func (c *regionColumns) setChunk(crd coord, minY int, heights *level.BitStorage, biomes *[256]save.BiomeState, tints *[256]blendColumn) {
+	c.ChunkMinY[crd.Z*32+crd.X] = int16(minY)
+	for idx := 0; idx < 256; idx++ {
+		regionIdx := columnIndex(crd, idx)
+		c.Heights[regionIdx] = int16(heights.Get(idx))
+		if biomes != nil {
+			c.setBiome(regionIdx, biomes[idx])
+		}
+		if tints != nil {
+			c.Tints[regionIdx] = tints[idx].kind
+			c.Depths[regionIdx] = 0
+			if tints[idx].darkened {
+				c.Depths[regionIdx] = tints[idx].depth + 1
+			}
+		}
+	}
+}
+
+// clearChunk removes a chunk which no longer has anything drawn
+func (c *regionColumns) clearChunk(crd coord) {
+	c.ChunkMinY[crd.Z*32+crd.X] = 0
+	for idx := 0; idx < 256; idx++ {
+		regionIdx := columnIndex(crd, idx)
+		c.Heights[regionIdx] = noHeight
+		c.BiomeIDs[regionIdx] = 0
+		c.Tints[regionIdx] = tintNone
+		c.Depths[regionIdx] = 0
+	}
+}
+
+// hasChunk reports whether any column of a chunk has a height
+func (c *regionColumns) hasChunk(crd coord) bool {
+	for idx := 0; idx < 256; idx++ {
+		if c.Heights[columnIndex(crd, idx)] != noHeight {
+			return true
+		}
+	}
+	return false
+}
+
+// blendColumn returns what blending needs to re-tint a column of a chunk
+func (c *regionColumns) blendColumn(crd coord, idx int) blendColumn {
+	regionIdx := columnIndex(crd, idx)
+	column := blendColumn{kind: c.Tints[regionIdx]}
+	if depth := c.Depths[regionIdx]; depth > 0 {
+		column.darkened = true
+		column.depth = depth - 1
+	}
+	return column
+}
+
+// takeRegionChunks removes the chunks of a region from pending, returning them
+// keyed by their position within the region
+func takeRegionChunks[T any](pending map[coord]T, x, z int) map[coord]T {
+	chunks := make(map[coord]T)
+	for crd, chunk := range pending {
+		regionX, regionZ := int(math.Floor(float64(crd.X)/32.0)), int(math.Floor(float64(crd.Z)/32.0))
+		if regionX != x || regionZ != z {
+			continue
+		}
+		chunks[coord{X: crd.X - x*32, Z: crd.Z - z*32}] = chunk
+		delete(pending, crd)
+	}
+	return chunks
+}
+
+// storeRegionColumns updates the stored columns of the chunks drawn in a
+// region, drawn chunks missing from chunks no longer have anything drawn
+func storeRegionColumns[T any](dst string, x, z int, drawn []coord, chunks map[coord]T, set func(columns *regionColumns, crd coord, chunk T)) error {
+	columns := loadRegionColumns(dst, x, z)
+	if columns == nil {
+		columns = newRegionColumns()
+	}
+
+	for _, crd := range drawn {
+		chunk, ok := chunks[crd]
+		if !ok {
+			columns.clearChunk(crd)
+			continue
+		}
+		set(columns, crd, chunk)
+	}
+
+	err := columns.save(dst, x, z)
+	if err != nil {
+		return fmt.Errorf("failed to save region columns (%v, %v): %v", x, z, err)
+	}
+	return nil
+}
+
+// regionNeighborhood looks up columns of a region and the regions around it,
+// neighbors are only read from disk when a column near them is needed
+type regionNeighborhood struct {
+	dst    string
+	x, z   int
+	center *regionColumns
+
+	neighbors map[coord]*regionColumns
+	loaded    map[coord]bool
+}
+
+func newRegionNeighborhood(dst string, x, z int, center *regionColumns) *regionNeighborhood {
+	return &regionNeighborhood{
+		dst:       dst,
+		x:         x,
+		z:         z,
+		center:    center,
+		neighbors: make(map[coord]*regionColumns),
+		loaded:    make(map[coord]bool),
+	}
+}
+
+// columns returns the region containing a column in region relative
+// coordinates along with the columns index within it
+func (n *regionNeighborhood) columns(x, z int) (*regionColumns, int) {
+	offset := coord{X: int(math.Floor(float64(x) / 512)), Z: int(math.Floor(float64(z) / 512))}
+	idx := ((z - offset.Z*512) * 512) + (x - offset.X*512)
+	if offset.X == 0 && offset.Z == 0 {
+		return n.center, idx
+	}
+
+	if !n.loaded[offset] {
+		n.loaded[offset] = true
+		n.neighbors[offset] = loadRegionColumns(n.dst, n.x+offset.X, n.z+offset.Z)
+	}
+	return n.neighbors[offset], idx
+}
+
+// height returns the y level of the first free block above a column in region
+// relative coordinates, the bottom of the world for columns without blocks
+func (n *regionNeighborhood) height(x, z int) (int, bool) {
+	columns, idx := n.columns(x, z)
+	if columns == nil || columns.Heights[idx] == noHeight {
+		return 0, false
+	}
+	return int(columns.Heights[idx]) + int(columns.ChunkMinY[(idx/512/16)*32+(idx%512)/16]), true
+}
+
+// biome returns the biome of a column in region relative coordinates
+func (n *regionNeighborhood) biome(x, z int) save.BiomeState {
+	columns, idx := n.columns(x, z)
+	if columns == nil {
+		return ""
+	}
+	return columns.Biomes[columns.BiomeIDs[idx]]
+}
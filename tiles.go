@@ -0,0 +1,188 @@
+package carto
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// RegionTileZoom is the zoom level at which one region image maps to exactly one tile
+const RegionTileZoom = 3
+
+// DefaultZoomLevels is the number of downsampled zoom levels generated below RegionTileZoom
+const DefaultZoomLevels = 3
+
+// regionImagePath returns the path to the full resolution image for a region
+func regionImagePath(path string, crd coord) string {
+	return filepath.Join(path, fmt.Sprintf("r.%d.%d.png", crd.X, crd.Z))
+}
+
+// tileImagePath returns the path to a tile at the given zoom level, region tiles
+// live at the root of the layer directory while downsampled tiles are stored as
+// <zoom>/<x>/<y>.png
+func tileImagePath(path string, zoom int, crd coord) string {
+	if zoom == RegionTileZoom {
+		return regionImagePath(path, crd)
+	}
+	return filepath.Join(path, strconv.Itoa(zoom), strconv.Itoa(crd.X), fmt.Sprintf("%d.png", crd.Z))
+}
+
+// listRegionImages returns the coordinates of every region image in a layer directory
+func listRegionImages(path string) (map[coord]struct{}, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[coord]struct{})
+	for _, e := range entries {
+		var crd coord
+		if _, err := fmt.Sscanf(e.Name(), "r.%d.%d.png", &crd.X, &crd.Z); err != nil {
+			continue
+		}
+		result[crd] = struct{}{}
+	}
+	return result, nil
+}
+
+// renderTilePyramid generates the downsampled zoom levels for a layer, only
+// re-rendering the parents of tiles which have changed
+func renderTilePyramid(path string, changed map[coord]struct{}, levels int, concurrency int) error {
+	guard := make(chan struct{}, concurrency)
+
+	zoom := RegionTileZoom
+	for i := 0; i < levels; i++ {
+		parents := make(map[coord]struct{})
+		for crd := range changed {
+			parents[coord{
+				X: int(math.Floor(float64(crd.X) / 2.0)),
+				Z: int(math.Floor(float64(crd.Z) / 2.0)),
+			}] = struct{}{}
+		}
+
+		var wg sync.WaitGroup
+		var firstErr error
+		var errLock sync.Mutex
+		for crd := range parents {
+			guard <- struct{}{}
+			wg.Add(1)
+			go func(crd coord) {
+				defer wg.Done()
+				defer func() {
+					<-guard
+				}()
+
+				err := renderPyramidTile(path, zoom, crd)
+				if err != nil {
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errLock.Unlock()
+				}
+			}(crd)
+		}
+		wg.Wait()
+
+		if firstErr != nil {
+			return firstErr
+		}
+
+		changed = parents
+		zoom--
+	}
+
+	return nil
+}
+
+// renderPyramidTile merges and downsamples the four children of a tile at zoom
+// level childZoom into their parent tile
+func renderPyramidTile(path string, childZoom int, parent coord) error {
+	var merged *image.RGBA
+	for dx := 0; dx < 2; dx++ {
+		for dz := 0; dz < 2; dz++ {
+			childPath := tileImagePath(path, childZoom, coord{X: parent.X*2 + dx, Z: parent.Z*2 + dz})
+
+			fd, err := os.Open(childPath)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			child, err := png.Decode(fd)
+			fd.Close()
+			if err != nil {
+				return fmt.Errorf("failed to decode tile %s: %v", childPath, err)
+			}
+
+			bounds := child.Bounds()
+			if merged == nil {
+				merged = image.NewRGBA(image.Rect(0, 0, bounds.Dx()*2, bounds.Dy()*2))
+			}
+
+			draw.Draw(merged, bounds.Add(image.Point{dx * bounds.Dx(), dz * bounds.Dy()}), child, bounds.Min, draw.Src)
+		}
+	}
+
+	dst := tileImagePath(path, childZoom-1, parent)
+
+	// all children are gone so the parent should be too
+	if merged == nil {
+		err := os.Remove(dst)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	if err := png.Encode(fd, downsampleImage(merged)); err != nil {
+		return fmt.Errorf("failed to encode tile png %s: %v", dst, err)
+	}
+
+	return nil
+}
+
+// downsampleImage halves the size of an image by averaging each 2x2 block of pixels
+func downsampleImage(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()/2, bounds.Dy()/2))
+
+	for x := 0; x < bounds.Dx()/2; x++ {
+		for y := 0; y < bounds.Dy()/2; y++ {
+			var sum [4]int
+			for dx := 0; dx < 2; dx++ {
+				for dy := 0; dy < 2; dy++ {
+					offset := src.PixOffset(x*2+dx, y*2+dy)
+					for i := 0; i < 4; i++ {
+						sum[i] += int(src.Pix[offset+i])
+					}
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				dst.Pix[offset+i] = uint8(sum[i] / 4)
+			}
+		}
+	}
+
+	return dst
+}
@@ -7,10 +7,29 @@ type FrontendData struct {
 type MapData struct {
 	Name   string      `json:"name"`
 	Layers []LayerData `json:"layers"`
+
+	// Markers is set when the map has a markers.json next to its layers
+	Markers bool `json:"markers"`
+
+	// Bounds is the rendered area in block coordinates, nil when unbounded
+	Bounds *BoundsData `json:"bounds,omitempty"`
+}
+
+type BoundsData struct {
+	MinX int `json:"min_x"`
+	MinZ int `json:"min_z"`
+	MaxX int `json:"max_x"`
+	MaxZ int `json:"max_z"`
 }
 
 type LayerData struct {
-	Name     string  `json:"name"`
-	TileSize int     `json:"tileSize"`
-	Opacity  float64 `json:"opacity"`
+	Name          string  `json:"name"`
+	TileSize      int     `json:"tileSize"`
+	Opacity       float64 `json:"opacity"`
+	MinNativeZoom int     `json:"minNativeZoom"`
+	MaxNativeZoom int     `json:"maxNativeZoom"`
+	Projection    string  `json:"projection"`
+
+	// Legend is the name of a file next to the layers tiles listing the colors it uses
+	Legend string `json:"legend,omitempty"`
 }
@@ -39,12 +39,29 @@ var MapSelector = L.Control.extend({
 		});
 
 		this._layers[name].layer.addTo(map);
+		for (const group of Object.values(this._layers[name].markers)) {
+			group.addTo(map);
+		}
+		map.projection = this._layers[name].projection;
 		if (this._layers[name].control !== undefined) {
 			this._layers[name].control.addTo(map);
 		}
 		this._currentLayer = name;
 
-		map.setView([0, 0], 3);
+		// keep panning within the rendered area, isometric tiles do not line up
+		// with block coordinates so they are never clamped
+		const bounds = this._layers[name].bounds;
+		if (bounds !== undefined && map.projection !== 'isometric') {
+			const area = L.latLngBounds(
+				map.unproject([bounds.min_x, bounds.min_z], 3),
+				map.unproject([bounds.max_x + 1, bounds.max_z + 1], 3),
+			);
+			map.setMaxBounds(area.pad(0.1));
+			map.fitBounds(area);
+		} else {
+			map.setMaxBounds(null);
+			map.setView([0, 0], 3);
+		}
 	},
 
 	makeItem: function (map, layer, checked) {
@@ -71,6 +88,12 @@ var CoordViewer = L.Control.extend({
 		container.style.textAlign = 'right';
 
 		map.on('mousemove', event => {
+			// isometric tiles have no direct mapping back to block coordinates
+			if (map.projection === 'isometric') {
+				gauge.innerHTML = '';
+				return;
+			}
+
 			var coords = coord = map.project(event.latlng, 3);
 			gauge.innerHTML = 'Coords: ' + Math.round(coords.x) + ", " + Math.round(coords.y);
 		})
@@ -80,6 +103,176 @@ var CoordViewer = L.Control.extend({
 	}
 });
 
+var CartoTileLayer = L.TileLayer.extend({
+	initialize(baseUrl, options) {
+		L.TileLayer.prototype.initialize.call(this, baseUrl, options);
+		this._baseUrl = baseUrl;
+	},
+
+	getTileUrl: function (coords) {
+		const zoom = this._getZoomForUrl();
+		const version = this.options.version ? `?v=${this.options.version}` : '';
+
+		// full resolution tiles are the region images themselves
+		if (zoom === this.options.maxNativeZoom) {
+			return `${this._baseUrl}/r.${coords.x}.${coords.y}.png${version}`;
+		}
+		return `${this._baseUrl}/${zoom}/${coords.x}/${coords.y}.png${version}`;
+	}
+});
+
+// Legend lists the colors used by the visible layers which have a legend file
+var Legend = L.Control.extend({
+	initialize(options) {
+		L.Util.setOptions(this, options);
+
+		this._layers = new Set();
+		this._entries = new Map();
+	},
+
+	onAdd: function (map) {
+		this._container = L.DomUtil.create('div', 'leaflet-control-layers leaflet-control-layers-expanded carto-legend');
+		L.DomEvent.disableClickPropagation(this._container);
+		L.DomEvent.disableScrollPropagation(this._container);
+
+		map.on('layeradd', (e) => {
+			if (e.layer.options && e.layer.options.legend) {
+				this._layers.add(e.layer);
+				this._load(e.layer);
+			}
+		});
+		map.on('layerremove', (e) => {
+			if (this._layers.delete(e.layer)) {
+				this._update();
+			}
+		});
+
+		this._update();
+		return this._container;
+	},
+
+	// refresh reloads the legends of the visible layers after a rebuild
+	refresh: function (version) {
+		for (const layer of this._layers) {
+			this._load(layer, version);
+		}
+	},
+
+	_load: function (layer, version) {
+		const url = layer.options.legend + (version ? `?v=${version}` : '');
+
+		fetch(url).then((res) => res.json()).then((entries) => {
+			this._entries.set(layer, entries);
+			this._update();
+		}).catch((err) => {
+			console.error(`failed to load legend ${layer.options.legend}`, err);
+		});
+	},
+
+	_update: function () {
+		this._container.innerHTML = '';
+
+		let visible = false;
+		for (const layer of this._layers) {
+			const entries = this._entries.get(layer);
+			if (entries === undefined) {
+				continue;
+			}
+			visible = true;
+
+			for (const entry of entries) {
+				const row = L.DomUtil.create('div', 'carto-legend-entry', this._container);
+				const swatch = L.DomUtil.create('span', 'carto-legend-swatch', row);
+				swatch.style.background = entry.color;
+				L.DomUtil.create('span', '', row).innerText = entry.name.replace(/^minecraft:/, '');
+			}
+		}
+
+		this._container.style.display = visible ? '' : 'none';
+	}
+});
+
+const markerStyles = {
+	player: { label: 'Players', color: '#3388ff' },
+	spawn: { label: 'Spawn', color: '#ffcc00' },
+	sign: { label: 'Signs', color: '#a0703c' },
+	banner: { label: 'Banners', color: '#e03030' },
+	lodestone: { label: 'Lodestones', color: '#808080' },
+};
+
+function escapeHTML(value) {
+	const div = document.createElement('div');
+	div.innerText = value;
+	return div.innerHTML;
+}
+
+// loadMarkers fetches the markers for a map into one toggleable group per type
+function loadMarkers(map, mapInfo, version) {
+	const url = `tiles/${mapInfo.name}/markers.json` + (version ? `?v=${version}` : '');
+
+	return fetch(url).then((res) => res.json()).then((markers) => {
+		for (const group of Object.values(mapInfo.markers)) {
+			group.clearLayers();
+		}
+
+		for (const marker of markers) {
+			const style = markerStyles[marker.type] || { label: marker.type, color: '#ffffff' };
+
+			if (mapInfo.markers[marker.type] === undefined) {
+				mapInfo.markers[marker.type] = L.layerGroup();
+				mapInfo.control.addOverlay(mapInfo.markers[marker.type], style.label);
+
+				if (map.hasLayer(mapInfo.layer)) {
+					mapInfo.markers[marker.type].addTo(map);
+				}
+			}
+
+			const lines = [marker.name, ...(marker.text || [])].filter((line) => line);
+			lines.push(`${marker.x}, ${marker.y}, ${marker.z}`);
+
+			L.circleMarker(map.unproject([marker.x + 0.5, marker.z + 0.5], 3), {
+				radius: 5,
+				color: '#000000',
+				weight: 1,
+				fillColor: style.color,
+				fillOpacity: 1,
+			}).bindPopup(lines.map(escapeHTML).join('<br>')).addTo(mapInfo.markers[marker.type]);
+		}
+	}).catch((err) => {
+		console.error(`failed to load markers for ${mapInfo.name}`, err);
+	});
+}
+
+// listenForReloads redraws all tile layers whenever `carto serve --watch`
+// finishes a build, on other web servers the event stream 404s and is closed
+function listenForReloads(tileLayers, onReload) {
+	if (!window.EventSource) {
+		return;
+	}
+
+	const events = new EventSource('_carto/events');
+	events.onerror = () => {
+		if (events.readyState === EventSource.CLOSED) {
+			return;
+		}
+
+		// only give up if we never connected
+		if (!events._connected) {
+			events.close();
+		}
+	};
+	events.onopen = () => {
+		events._connected = true;
+	};
+	events.addEventListener('reload', (e) => {
+		for (const layer of tileLayers) {
+			layer.options.version = e.data;
+			layer.redraw();
+		}
+		onReload(e.data);
+	});
+}
+
 function init(data) {
 	const map = L.map('map', {
 		crs: L.CRS.Simple,
@@ -89,25 +282,32 @@ function init(data) {
 	});
 
 	let maps = {};
+	let tileLayers = [];
 
 	for (const mapData of data.maps) {
 		let layers = {};
 		let mainLayer;
+		let projection;
 
 		for (const layer of mapData.layers) {
-			let thisLayer = L.tileLayer(`/tiles/${mapData.name}/${layer.name}/r.{x}.{y}.png`, {
+			// tile urls are relative so the map can be served under any path
+			let thisLayer = new CartoTileLayer(`tiles/${mapData.name}/${layer.name}`, {
 				attribution: 'carto',
-				minNativeZoom: 3,
-				maxNativeZoom: 3,
-				minZoom: 0,
-				maxZoom: 5,
+				minNativeZoom: layer.minNativeZoom,
+				maxNativeZoom: layer.maxNativeZoom,
+				minZoom: Math.min(layer.minNativeZoom, 0),
+				maxZoom: layer.maxNativeZoom + 2,
 				tileSize: layer.tileSize,
 				noWrap: true,
-				opacity: layer.opacity === 0 ? 1 : layer.opacity
+				opacity: layer.opacity === 0 ? 1 : layer.opacity,
+				legend: layer.legend ? `tiles/${mapData.name}/${layer.name}/${layer.legend}` : undefined
 			});
 
+			tileLayers.push(thisLayer);
+
 			if (mainLayer === undefined) {
 				mainLayer = thisLayer;
+				projection = layer.projection;
 			} else {
 				layers[layer.name] = thisLayer;
 			}
@@ -116,14 +316,35 @@ function init(data) {
 		maps[mapData.name] = {
 			name: mapData.name,
 			layer: mainLayer,
+			projection: projection,
+			markers: {},
+			hasMarkers: mapData.markers,
+			bounds: mapData.bounds,
 		};
 
-		if (Object.keys(layers).length > 0) {
+		if (Object.keys(layers).length > 0 || mapData.markers) {
 			maps[mapData.name].control = L.control.layers({}, layers, { collapsed: false });
 		}
 	}
 
+	const legend = new Legend({ position: "bottomright" });
+	legend.addTo(map);
+
 	(new CoordViewer({ position: "bottomleft" })).addTo(map);
 	(new MapSelector(maps)).addTo(map);
+
+	const reloadMarkers = (version) => {
+		for (const mapInfo of Object.values(maps)) {
+			if (mapInfo.hasMarkers) {
+				loadMarkers(map, mapInfo, version);
+			}
+		}
+	};
+	reloadMarkers();
+
+	listenForReloads(tileLayers, (version) => {
+		reloadMarkers(version);
+		legend.refresh(version);
+	});
 }
 